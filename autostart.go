@@ -0,0 +1,101 @@
+// This file holds the code that launches the panes listed in the autostart
+// section of the conf file, before any peer connects
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/creack/pty"
+	"github.com/tuzig/webexec/peers"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+// Autostart holds the settings of a pane that's started with the agent
+type Autostart struct {
+	Profile      string            `toml:"profile,omitempty"`
+	Command      []string          `toml:"command,omitempty"`
+	Cwd          string            `toml:"cwd,omitempty"`
+	Env          map[string]string `toml:"env,omitempty"`
+	Rows         uint16            `toml:"rows,omitempty"`
+	Cols         uint16            `toml:"cols,omitempty"`
+	Restart      string            `toml:"restart,omitempty"`
+	MaxRestarts  int               `toml:"max_restarts,omitempty"`
+	RestartDelay int               `toml:"restart_delay,omitempty"`
+}
+
+// StartAutostart launches the autostart panes when the agent starts
+func StartAutostart(lc fx.Lifecycle, conf *peers.Conf, logger *zap.SugaredLogger) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			for _, a := range Conf.autostart {
+				pane, err := launchAutostart(conf, a)
+				if err != nil {
+					logger.Errorf("Failed to autostart a pane: %s", err)
+					continue
+				}
+				logger.Infof("Autostarted pane %d", pane.ID)
+			}
+			return nil
+		},
+	})
+}
+
+// launchAutostart starts a pane with no peer attached. The output is kept in
+// the pane's buffer until a client reconnects to it
+func launchAutostart(conf *peers.Conf, a *Autostart) (*peers.Pane, error) {
+	args := peers.AddPaneArgs{
+		Profile:      a.Profile,
+		Command:      a.Command,
+		Cwd:          a.Cwd,
+		Env:          a.Env,
+		Rows:         a.Rows,
+		Cols:         a.Cols,
+		Restart:      a.Restart,
+		MaxRestarts:  a.MaxRestarts,
+		RestartDelay: a.RestartDelay,
+	}
+	if args.Profile != "" {
+		err := applyProfile(&args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(args.Command) == 0 {
+		return nil, fmt.Errorf("Missing command")
+	}
+	restart, err := peers.NewRestartPolicy(
+		args.Restart, args.MaxRestarts, args.RestartDelay)
+	if err != nil {
+		return nil, err
+	}
+	ws := &pty.Winsize{Rows: 24, Cols: 80}
+	if args.Rows > 0 && args.Cols > 0 {
+		ws = &pty.Winsize{Rows: args.Rows, Cols: args.Cols}
+	}
+	pane, err := peers.NewPane(conf, ws, 0)
+	if err != nil {
+		return nil, err
+	}
+	pane.Cwd = args.Cwd
+	pane.Env = args.Env
+	pane.Restart = restart
+	err = pane.AddTriggers(args.Triggers)
+	if err != nil {
+		peers.Panes.Delete(pane.ID)
+		return nil, err
+	}
+	err = forwardSockets(nil, pane, args.Sockets)
+	if err != nil {
+		peers.Panes.Delete(pane.ID)
+		return nil, err
+	}
+	err = pane.Run(resolveShell(args.Command))
+	if err != nil {
+		peers.Panes.Delete(pane.ID)
+		closePaneListeners(pane)
+		return nil, err
+	}
+	return pane, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuzig/webexec/peers"
+)
+
+func TestAutostart(t *testing.T) {
+	initTest(t)
+	_, _, err := parseConf(`
+[[autostart]]
+command = [ "bash", "-c", "echo $BADWOLF" ]
+rows = 12
+cols = 34
+[autostart.env]
+BADWOLF = "Rose"
+`)
+	require.NoError(t, err)
+	require.Len(t, Conf.autostart, 1)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, Conf.autostart[0])
+	require.NoError(t, err)
+	require.NotNil(t, peers.Panes.Get(pane.ID))
+	require.EqualValues(t, 34, pane.Ws.Cols)
+	for i := 0; i < 20; i++ {
+		pane.Lock()
+		running := pane.IsRunning
+		pane.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Contains(t, string(pane.Buffer.GetSinceMarker(-1)), "Rose")
+	_, _, err = parseConf("[[autostart]]\nprofile = \"missing\"\n")
+	require.Error(t, err)
+}
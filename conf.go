@@ -3,10 +3,12 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/user"
 	"path/filepath"
 	"regexp"
+	"syscall"
 	"time"
 
 	"github.com/pelletier/go-toml"
@@ -41,9 +43,17 @@ keep_alive = 500
 peerbook = 3000
 [[ice_servers]]
 urls = [ "stun:stun.l.google.com:19302" ]
+[panes]
+kill_signal = "HUP"
+kill_grace = 5000
 [env]
+policy = "inherit"
 COLORTERM = "truecolor"
 TERM = "xterm-256color"
+[forward]
+allow = [ "localhost:*", "127.0.0.1:*" ]
+[socks]
+allow = [ "127.0.0.0/8", "::1", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7" ]
 `
 const abConfTemplate = `%s[peerbook]
 host = "%s"
@@ -58,6 +68,21 @@ type ICEServer struct {
 	Password string   `toml:"password,omitempty"`
 }
 
+// Profile holds the settings of a named pane profile
+type Profile struct {
+	Name         string                `toml:"-" json:"name"`
+	Command      []string              `toml:"command" json:"command"`
+	Cwd          string                `toml:"cwd,omitempty" json:"cwd,omitempty"`
+	Env          map[string]string     `toml:"env,omitempty" json:"-"`
+	Rows         uint16                `toml:"rows,omitempty" json:"rows,omitempty"`
+	Cols         uint16                `toml:"cols,omitempty" json:"cols,omitempty"`
+	Restart      string                `toml:"restart,omitempty" json:"restart,omitempty"`
+	MaxRestarts  int                   `toml:"max_restarts,omitempty" json:"max_restarts,omitempty"`
+	RestartDelay int                   `toml:"restart_delay,omitempty" json:"restart_delay,omitempty"`
+	Triggers     []peers.Trigger       `toml:"triggers,omitempty" json:"triggers,omitempty"`
+	Sockets      []peers.SocketForward `toml:"sockets,omitempty" json:"sockets,omitempty"`
+}
+
 // Conf hold the configuration variables
 var Conf struct {
 	logFilePath     string
@@ -70,6 +95,12 @@ var Conf struct {
 	peerbookUID     string
 	name            string
 	peerConf        *peers.Conf
+	profiles        map[string]*Profile
+	autostart       []*Autostart
+	forwardAllow    []destRule
+	listenPublic    bool
+	socksAllow      []*net.IPNet
+	socksDeny       []*net.IPNet
 	T               *toml.Tree
 }
 
@@ -178,6 +209,22 @@ func parseConf(s string) (*peers.Conf, httpserver.AddressType, error) {
 	} else {
 		peersConf.PortMax = 61000
 	}
+	// how to terminate panes
+	v = t.Get("panes.kill_signal")
+	if v != nil {
+		peersConf.KillSignal, err = peers.ParseKillSignal(v.(string))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse panes.kill_signal: %s", err)
+		}
+	} else {
+		peersConf.KillSignal = syscall.SIGHUP
+	}
+	v = t.Get("panes.kill_grace")
+	if v != nil {
+		peersConf.KillGrace = time.Duration(v.(int64)) * time.Millisecond
+	} else {
+		peersConf.KillGrace = 5 * time.Second
+	}
 	// unsecured cotrol which shema to use
 	v = t.Get("peerbook.insecure")
 	if v != nil {
@@ -185,12 +232,113 @@ func parseConf(s string) (*peers.Conf, httpserver.AddressType, error) {
 	}
 	// get env vars
 	peersConf.Env = map[string]string{"WEBEXEC": GetSockFP()}
+	peersConf.EnvPolicy = peers.EnvPolicyClean
 	m := t.Get("env")
 	if m != nil {
 		for k, v := range m.(*toml.Tree).ToMap() {
+			if k == "policy" {
+				peersConf.EnvPolicy = v.(string)
+				continue
+			}
 			peersConf.Env[k] = v.(string)
 		}
 	}
+	if !peers.ValidEnvPolicy(peersConf.EnvPolicy) {
+		return nil, "", fmt.Errorf("unknown env.policy: %q", peersConf.EnvPolicy)
+	}
+	Conf.profiles = make(map[string]*Profile)
+	v = t.Get("profiles")
+	if v != nil {
+		profiles := v.(*toml.Tree)
+		for _, name := range profiles.Keys() {
+			tree, ok := profiles.Get(name).(*toml.Tree)
+			if !ok {
+				return nil, "", fmt.Errorf("profile %q is not a table", name)
+			}
+			p := Profile{Name: name}
+			err := tree.Unmarshal(&p)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to parse profile %q: %s", name, err)
+			}
+			if len(p.Command) == 0 {
+				return nil, "", fmt.Errorf("profile %q has no command", name)
+			}
+			_, err = peers.NewRestartPolicy(p.Restart, p.MaxRestarts, p.RestartDelay)
+			if err != nil {
+				return nil, "", fmt.Errorf("profile %q: %s", name, err)
+			}
+			for i := range p.Triggers {
+				err = p.Triggers[i].Compile()
+				if err != nil {
+					return nil, "", fmt.Errorf("profile %q: %s", name, err)
+				}
+			}
+			err = checkSockets(p.Sockets)
+			if err != nil {
+				return nil, "", fmt.Errorf("profile %q: %s", name, err)
+			}
+			Conf.profiles[name] = &p
+		}
+	}
+	Conf.autostart = nil
+	v = t.Get("autostart")
+	if v != nil {
+		trees, ok := v.([]*toml.Tree)
+		if !ok {
+			return nil, "", fmt.Errorf("autostart should be an array of tables")
+		}
+		for i, tree := range trees {
+			var a Autostart
+			err := tree.Unmarshal(&a)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to parse autostart #%d: %s", i, err)
+			}
+			if len(a.Command) == 0 && a.Profile == "" {
+				return nil, "", fmt.Errorf("autostart #%d has no command or profile", i)
+			}
+			if a.Profile != "" && Conf.profiles[a.Profile] == nil {
+				return nil, "", fmt.Errorf("autostart #%d has an unknown profile: %q", i, a.Profile)
+			}
+			_, err = peers.NewRestartPolicy(a.Restart, a.MaxRestarts, a.RestartDelay)
+			if err != nil {
+				return nil, "", fmt.Errorf("autostart #%d: %s", i, err)
+			}
+			Conf.autostart = append(Conf.autostart, &a)
+		}
+	}
+	entries, err := getStrings(t, "forward.allow")
+	if err != nil {
+		return nil, "", err
+	}
+	Conf.forwardAllow, err = parseDestRules(entries)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse forward.allow: %s", err)
+	}
+	Conf.listenPublic = false
+	v = t.Get("forward.listen_public")
+	if v != nil {
+		listenPublic, ok := v.(bool)
+		if !ok {
+			return nil, "", fmt.Errorf("forward.listen_public should be a boolean")
+		}
+		Conf.listenPublic = listenPublic
+	}
+	entries, err = getStrings(t, "socks.allow")
+	if err != nil {
+		return nil, "", err
+	}
+	Conf.socksAllow, err = parseNetRules(entries)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse socks.allow: %s", err)
+	}
+	entries, err = getStrings(t, "socks.deny")
+	if err != nil {
+		return nil, "", err
+	}
+	Conf.socksDeny, err = parseNetRules(entries)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse socks.deny: %s", err)
+	}
 	v = t.Get("peerbook.user_id")
 	if v != nil {
 		Conf.peerbookUID = v.(string)
@@ -249,10 +397,34 @@ func LoadConf(certs []webrtc.Certificate) (*peers.Conf, httpserver.AddressType,
 	conf.GetICEServers = GetICEServers
 	conf.GetWelcome = GetWelcome
 	conf.OnCTRLMsg = handleCTRLMsg
+	conf.OnCTRLClose = handleCTRLClose
+	conf.OnSOCKSChannel = handleSOCKSChannel
 
 	return conf, addr, err
 }
 
+// getStrings returns the value of a key holding an array of strings, or nil
+// if the key is missing
+func getStrings(t *toml.Tree, key string) ([]string, error) {
+	v := t.Get(key)
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s should be an array of strings", key)
+	}
+	var ret []string
+	for _, e := range list {
+		s, ok := e.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s should be an array of strings", key)
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
 func isValidEmail(email string) bool {
 	if len(email) < 3 && len(email) > 254 {
 		return false
@@ -2,9 +2,13 @@
 package main
 
 import (
+	"net"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tuzig/webexec/peers"
 )
 
 func TestConfEnv(t *testing.T) {
@@ -12,3 +16,121 @@ func TestConfEnv(t *testing.T) {
 	require.EqualValues(t, Conf.peerConf.Env["TERM"], "xterm-256color")
 	require.EqualValues(t, Conf.peerConf.Env["COLORTERM"], "truecolor")
 }
+func TestConfPanes(t *testing.T) {
+	initTest(t)
+	require.Equal(t, syscall.SIGHUP, Conf.peerConf.KillSignal)
+	require.Equal(t, 5*time.Second, Conf.peerConf.KillGrace)
+	_, _, err := parseConf("[panes]\nkill_signal = \"TSTP\"\n")
+	require.Error(t, err)
+}
+func TestConfEnvPolicy(t *testing.T) {
+	initTest(t)
+	require.Equal(t, peers.EnvPolicyInherit, Conf.peerConf.EnvPolicy)
+	require.NotContains(t, Conf.peerConf.Env, "policy")
+	_, _, err := parseConf("[env]\npolicy = \"dirty\"\n")
+	require.Error(t, err)
+}
+func TestConfProfiles(t *testing.T) {
+	initTest(t)
+	_, _, err := parseConf(`
+[profiles.dev]
+command = [ "bash", "-l" ]
+cwd = "~/src"
+rows = 40
+cols = 120
+[profiles.dev.env]
+NODE_ENV = "development"
+`)
+	require.NoError(t, err)
+	p := Conf.profiles["dev"]
+	require.NotNil(t, p)
+	require.Equal(t, "dev", p.Name)
+	require.Equal(t, []string{"bash", "-l"}, p.Command)
+	require.Equal(t, "~/src", p.Cwd)
+	require.EqualValues(t, 40, p.Rows)
+	require.EqualValues(t, 120, p.Cols)
+	require.Equal(t, "development", p.Env["NODE_ENV"])
+	_, _, err = parseConf("[profiles.empty]\ncwd = \"/tmp\"\n")
+	require.Error(t, err)
+	_, _, err = parseConf(`
+[profiles.test]
+command = [ "go", "test", "./..." ]
+[[profiles.test.triggers]]
+pattern = "FAIL"
+action = "notify"
+`)
+	require.NoError(t, err)
+	require.Len(t, Conf.profiles["test"].Triggers, 1)
+	require.Equal(t, "notify", Conf.profiles["test"].Triggers[0].Action)
+	_, _, err = parseConf(`
+[profiles.test]
+command = [ "go", "test", "./..." ]
+[[profiles.test.triggers]]
+pattern = "FAIL"
+action = "shout"
+`)
+	require.Error(t, err)
+	_, _, err = parseConf(`
+[profiles.dev]
+command = [ "bash" ]
+sockets = [ { name = "ssh-agent" }, { name = "gpg-agent", env = "GPG_SOCK" } ]
+`)
+	require.NoError(t, err)
+	require.Equal(t, []peers.SocketForward{{Name: "ssh-agent"},
+		{Name: "gpg-agent", Env: "GPG_SOCK"}}, Conf.profiles["dev"].Sockets)
+	_, _, err = parseConf(`
+[profiles.dev]
+command = [ "bash" ]
+sockets = [ { name = "gpg-agent" } ]
+`)
+	require.Error(t, err)
+}
+func TestConfForward(t *testing.T) {
+	initTest(t)
+	require.True(t, destAllowed(Conf.forwardAllow, "localhost", 3000))
+	require.False(t, destAllowed(Conf.forwardAllow, "10.0.0.1", 3000))
+	_, _, err := parseConf("[forward]\nallow = [ \"10.0.0.1:5432\" ]\n")
+	require.NoError(t, err)
+	require.True(t, destAllowed(Conf.forwardAllow, "10.0.0.1", 5432))
+	require.False(t, destAllowed(Conf.forwardAllow, "localhost", 3000))
+	_, _, err = parseConf("")
+	require.NoError(t, err)
+	require.False(t, destAllowed(Conf.forwardAllow, "localhost", 3000))
+	_, _, err = parseConf("[forward]\nallow = [ \"localhost\" ]\n")
+	require.Error(t, err)
+}
+func TestConfListenPublic(t *testing.T) {
+	initTest(t)
+	require.True(t, listenAllowed("127.0.0.1"))
+	require.True(t, listenAllowed("localhost"))
+	require.False(t, listenAllowed("0.0.0.0"))
+	_, _, err := parseConf("[forward]\nlisten_public = true\n")
+	require.NoError(t, err)
+	require.True(t, listenAllowed("0.0.0.0"))
+	_, _, err = parseConf("[forward]\nlisten_public = \"yes\"\n")
+	require.Error(t, err)
+	_, _, err = parseConf("")
+	require.NoError(t, err)
+	require.False(t, listenAllowed("0.0.0.0"))
+}
+func TestConfSOCKS(t *testing.T) {
+	initTest(t)
+	require.True(t, socksAllowed(net.ParseIP("192.168.1.1")))
+	require.True(t, socksAllowed(net.ParseIP("127.0.0.1")))
+	require.False(t, socksAllowed(net.ParseIP("8.8.8.8")))
+	_, _, err := parseConf(`[socks]
+allow = [ "10.0.0.0/8" ]
+deny = [ "10.0.0.1" ]
+`)
+	require.NoError(t, err)
+	require.True(t, socksAllowed(net.ParseIP("10.0.0.2")))
+	require.False(t, socksAllowed(net.ParseIP("10.0.0.1")))
+	require.False(t, socksAllowed(net.ParseIP("192.168.1.1")))
+	_, _, err = parseConf("")
+	require.NoError(t, err)
+	require.False(t, socksAllowed(net.ParseIP("127.0.0.1")))
+	_, _, err = parseConf("[socks]\nallow = [ \"10.0.0.0/40\" ]\n")
+	require.Error(t, err)
+	_, _, err = parseConf("[socks]\ndeny = \"10.0.0.0/8\"\n")
+	require.Error(t, err)
+}
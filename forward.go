@@ -0,0 +1,286 @@
+// This file holds the code that forwards TCP connections over data channels
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// forwardBufferSize is the size of the reads from forwarded connections
+const forwardBufferSize = 16 * 1024
+
+// maxForwardBuffered is the amount of data waiting in a data channel's buffer
+// that pauses the reading from the forwarded connection
+const maxForwardBuffered = 1024 * 1024
+
+// forwardDialTimeout is how long to wait for a target to accept a connection
+const forwardDialTimeout = 5 * time.Second
+
+// destRule is an allowlist entry, in the form `host:port`. The host can be `*`
+// or start with `*.` to match a domain. The port can be `*` or a range
+type destRule struct {
+	host    string
+	portMin int
+	portMax int
+}
+
+// parseDestRule parses an allowlist entry
+func parseDestRule(s string) (destRule, error) {
+	var r destRule
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return r, fmt.Errorf("bad destination %q: %s", s, err)
+	}
+	if host == "" {
+		return r, fmt.Errorf("bad destination %q: missing host", s)
+	}
+	r.host = strings.ToLower(host)
+	if port == "*" {
+		r.portMin, r.portMax = 1, 65535
+		return r, nil
+	}
+	min, max, isRange := strings.Cut(port, "-")
+	r.portMin, err = strconv.Atoi(min)
+	if err != nil {
+		return r, fmt.Errorf("bad destination %q: bad port", s)
+	}
+	r.portMax = r.portMin
+	if isRange {
+		r.portMax, err = strconv.Atoi(max)
+		if err != nil {
+			return r, fmt.Errorf("bad destination %q: bad port", s)
+		}
+	}
+	if r.portMin < 1 || r.portMax > 65535 || r.portMin > r.portMax {
+		return r, fmt.Errorf("bad destination %q: bad port range", s)
+	}
+	return r, nil
+}
+
+// match returns true if the rule allows connecting to host:port
+func (r destRule) match(host string, port int) bool {
+	if port < r.portMin || port > r.portMax {
+		return false
+	}
+	host = strings.ToLower(host)
+	if r.host == "*" || r.host == host {
+		return true
+	}
+	return strings.HasPrefix(r.host, "*.") && strings.HasSuffix(host, r.host[1:])
+}
+
+// parseDestRules parses a list of allowlist entries
+func parseDestRules(entries []string) ([]destRule, error) {
+	var rules []destRule
+	for _, e := range entries {
+		r, err := parseDestRule(e)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// destAllowed returns true if one of the rules allows connecting to host:port
+func destAllowed(rules []destRule, host string, port int) bool {
+	for _, r := range rules {
+		if r.match(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForwardStats holds a forward's details & byte counters. Sent is the number
+// of bytes sent to the peer and Received the number of bytes received from it
+type ForwardStats struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	FP       string `json:"fp"`
+	Target   string `json:"target"`
+	Created  int64  `json:"created"`
+	Sent     int64  `json:"bytes_sent"`
+	Received int64  `json:"bytes_received"`
+}
+
+// Write writes the stats as a row in a status table
+func (s *ForwardStats) Write(w *tabwriter.Writer, now time.Time) {
+	age := now.Sub(time.UnixMilli(s.Created)).Round(time.Second)
+	fmt.Fprintf(w, "  %d\t%s\t%s\t%s\t%d\t%d\t%s\n", s.ID, shortFP(s.FP), s.Type,
+		s.Target, s.Sent, s.Received, age)
+}
+
+// shortFP returns the start of a fingerprint, for tables
+func shortFP(fp string) string {
+	if len([]rune(fp)) > 6 {
+		return string([]rune(fp)[:6]) + "\uf141"
+	}
+	return fp
+}
+
+// Forward is a connection forwarded over a data channel
+type Forward struct {
+	id       int
+	typ      string
+	fp       string
+	target   string
+	created  time.Time
+	conn     net.Conn
+	sent     atomic.Int64
+	received atomic.Int64
+	once     sync.Once
+}
+
+var (
+	forwards      = map[int]*Forward{}
+	forwardsM     sync.Mutex
+	lastForwardID int
+)
+
+// newForward adds a forward of a connection to the forwards' db
+func newForward(typ string, fp string, target string, conn net.Conn) *Forward {
+	forwardsM.Lock()
+	defer forwardsM.Unlock()
+	lastForwardID++
+	f := &Forward{
+		id:      lastForwardID,
+		typ:     typ,
+		fp:      fp,
+		target:  target,
+		created: time.Now(),
+		conn:    conn,
+	}
+	forwards[f.id] = f
+	return f
+}
+
+// allForwardStats returns the stats of all the forwards, sorted by id
+func allForwardStats() []ForwardStats {
+	forwardsM.Lock()
+	ret := make([]ForwardStats, 0, len(forwards))
+	for _, f := range forwards {
+		ret = append(ret, f.Stats())
+	}
+	forwardsM.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// Stats returns the forward's details & counters
+func (f *Forward) Stats() ForwardStats {
+	return ForwardStats{
+		ID:       f.id,
+		Type:     f.typ,
+		FP:       f.fp,
+		Target:   f.target,
+		Created:  f.created.UnixMilli(),
+		Sent:     f.sent.Load(),
+		Received: f.received.Load(),
+	}
+}
+
+// pipe copies data between the connection & the data channel until one of them
+// is closed. It should be called once the data channel is open
+func (f *Forward) pipe(d *webrtc.DataChannel) {
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		f.write(d, msg.Data)
+	})
+	d.OnClose(func() {
+		f.close(d)
+	})
+	f.sendLoop(d)
+}
+
+// pipeStream is like pipe for a channel that's read by a channelStream. The
+// stream keeps handling the channel's messages, starting with the ones that
+// were received and not read
+func (f *Forward) pipeStream(d *webrtc.DataChannel, s *channelStream) {
+	go func() {
+		defer f.close(d)
+		for {
+			b, err := s.next()
+			if err != nil || !f.write(d, b) {
+				return
+			}
+		}
+	}()
+	f.sendLoop(d)
+}
+
+// write writes data received from the peer to the connection. It closes the
+// forward and returns false when it fails
+func (f *Forward) write(d *webrtc.DataChannel, b []byte) bool {
+	f.received.Add(int64(len(b)))
+	_, err := f.conn.Write(b)
+	if err != nil {
+		Logger.Infof("forward #%d: failed to write to %s: %s", f.id, f.target, err)
+		f.close(d)
+		return false
+	}
+	return true
+}
+
+// sendLoop reads the connection and sends the data to the peer, pausing when
+// the channel's buffer is full
+func (f *Forward) sendLoop(d *webrtc.DataChannel) {
+	lowBuffer := make(chan struct{}, 1)
+	d.SetBufferedAmountLowThreshold(maxForwardBuffered / 2)
+	d.OnBufferedAmountLow(func() {
+		select {
+		case lowBuffer <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		defer f.close(d)
+		b := make([]byte, forwardBufferSize)
+		for {
+			n, err := f.conn.Read(b)
+			if n > 0 {
+				for d.BufferedAmount() > maxForwardBuffered {
+					select {
+					case <-lowBuffer:
+					case <-time.After(time.Second):
+					}
+					if d.ReadyState() != webrtc.DataChannelStateOpen {
+						return
+					}
+				}
+				err := d.Send(b[:n])
+				if err != nil {
+					Logger.Infof("forward #%d: failed to send: %s", f.id, err)
+					return
+				}
+				f.sent.Add(int64(n))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// close closes the connection & the data channel and removes the forward
+func (f *Forward) close(d *webrtc.DataChannel) {
+	f.once.Do(func() {
+		f.conn.Close()
+		if d != nil {
+			d.Close()
+		}
+		forwardsM.Lock()
+		delete(forwards, f.id)
+		forwardsM.Unlock()
+		Logger.Infof("forward #%d to %s closed, sent %d bytes & received %d",
+			f.id, f.target, f.sent.Load(), f.received.Load())
+	})
+}
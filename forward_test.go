@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDestRules(t *testing.T) {
+	rules, err := parseDestRules([]string{
+		"localhost:3000", "127.0.0.1:*", "*.internal:8000-8080", "[::1]:22"})
+	require.NoError(t, err)
+	cases := []struct {
+		host    string
+		port    int
+		allowed bool
+	}{
+		{"localhost", 3000, true},
+		{"LocalHost", 3000, true},
+		{"localhost", 3001, false},
+		{"127.0.0.1", 1, true},
+		{"127.0.0.2", 80, false},
+		{"db.internal", 8000, true},
+		{"db.internal", 8081, false},
+		{"internal", 8000, false},
+		{"::1", 22, true},
+		{"example.com", 443, false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.allowed, destAllowed(rules, c.host, c.port),
+			"%s:%d", c.host, c.port)
+	}
+	for _, bad := range []string{"localhost", ":80", "host:0", "host:99999",
+		"host:90-80", "host:http"} {
+		_, err := parseDestRule(bad)
+		require.Error(t, err, bad)
+	}
+	require.True(t, destAllowed([]destRule{{"*", 1, 65535}}, "anything", 80))
+	require.False(t, destAllowed(nil, "localhost", 80))
+}
@@ -23,6 +23,7 @@ require (
 	go.uber.org/zap v1.23.0
 	golang.org/x/crypto v0.21.0
 	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
 
@@ -61,6 +62,5 @@ require (
 	go.uber.org/dig v1.16.1 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
 	golang.org/x/net v0.23.0 // indirect
-	golang.org/x/term v0.18.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
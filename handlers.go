@@ -3,6 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/creack/pty"
 	"github.com/pion/webrtc/v3"
@@ -24,7 +28,7 @@ func handleResize(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage
 		Logger.Error("Failed to parse resize message pane_id out of range")
 		return
 	}
-	if pane.TTY == nil {
+	if pane.GetTTY() == nil {
 		Logger.Warnf("Tried to resize a pane with no tty")
 		peer.SendNack(m, "Tried to resize a pane with no tty")
 		return
@@ -45,6 +49,190 @@ func handleResize(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage
 	}
 }
 
+// handleSignalPane handles signal_pane control messages.
+func handleSignalPane(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.SignalPaneArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a signal_pane message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	sig, err := peers.ParseSignal(args.Signal)
+	if err != nil {
+		Logger.Warnf("Failed to parse signal_pane message: %s", err)
+		peer.SendNack(m, err.Error())
+		return
+	}
+	Logger.Infof("@%d: sending signal %s, group: %t", pane.ID, sig, args.Group)
+	err = pane.Signal(sig, args.Group)
+	if err != nil {
+		Logger.Warnf("Failed to signal pane %d: %s", pane.ID, err)
+		peer.SendNack(m, fmt.Sprintf("Failed to signal pane: %s", err))
+		return
+	}
+	err = peer.SendAck(m, "")
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a signal_pane ack: %v", peer.FP, err)
+	}
+}
+
+// handleGetPaneInfo handles get_pane_info control messages.
+func handleGetPaneInfo(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.GetPaneInfoArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a get_pane_info message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	info, err := pane.ProcessInfo()
+	if err != nil {
+		Logger.Warnf("Failed to get pane %d info: %s", pane.ID, err)
+		peer.SendNack(m, fmt.Sprintf("Failed to get pane info: %s", err))
+		return
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		Logger.Errorf("Failed to marshal pane info: %s", err)
+		peer.SendNack(m, "Failed to marshal pane info")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a get_pane_info ack: %v", peer.FP, err)
+	}
+}
+
+// handleGetCommandHistory handles get_command_history control messages.
+func handleGetCommandHistory(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.GetCommandHistoryArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a get_command_history message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	b, err := json.Marshal(pane.CommandHistory(args.Limit))
+	if err != nil {
+		Logger.Errorf("Failed to marshal command history: %s", err)
+		peer.SendNack(m, "Failed to marshal command history")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a get_command_history ack: %v", peer.FP, err)
+	}
+}
+
+// handleGetCommandOutput handles get_command_output control messages.
+func handleGetCommandOutput(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.GetCommandOutputArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a get_command_output message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	out, err := pane.CommandOutput(args.ID)
+	if err != nil {
+		Logger.Warnf("Failed to get command output: %s", err)
+		peer.SendNack(m, fmt.Sprintf("Failed to get command output: %s", err))
+		return
+	}
+	if args.Plain {
+		out = peers.StripANSI(out)
+	}
+	err = peer.SendAck(m, string(out))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a get_command_output ack: %v", peer.FP, err)
+	}
+}
+
+// handleNotifyPane handles notify_pane control messages.
+func handleNotifyPane(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.NotifyPaneArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a notify_pane message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	if args.Threshold < 0 {
+		peer.SendNack(m, "Threshold can not be negative")
+		return
+	}
+	if args.Off {
+		pane.UnwatchCompletion()
+	} else {
+		pane.WatchCompletion(time.Duration(args.Threshold) * time.Millisecond)
+	}
+	err = peer.SendAck(m, "")
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a notify_pane ack: %v", peer.FP, err)
+	}
+}
+
+// handleWatchPane handles watch_pane control messages.
+func handleWatchPane(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
+	var args peers.WatchPaneArgs
+	err := json.Unmarshal(rawArgs, &args)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	pane := peers.Panes.Get(args.PaneID)
+	if pane == nil {
+		Logger.Warnf("Got a watch_pane message with a bad pane id: %d", args.PaneID)
+		peer.SendNack(m, fmt.Sprintf("Unknown pane id: %d", args.PaneID))
+		return
+	}
+	if args.Clear {
+		pane.ClearTriggers()
+	}
+	err = pane.AddTriggers(args.Triggers)
+	if err != nil {
+		Logger.Warnf("Failed to add triggers to pane %d: %s", pane.ID, err)
+		peer.SendNack(m, err.Error())
+		return
+	}
+	b, err := json.Marshal(pane.Triggers())
+	if err != nil {
+		Logger.Errorf("Failed to marshal triggers: %s", err)
+		peer.SendNack(m, "Failed to marshal triggers")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send a watch_pane ack: %v", peer.FP, err)
+	}
+}
+
 // handleRestore handles restore control messages.
 func handleRestore(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMessage) {
 	var args peers.RestoreArgs
@@ -87,10 +275,7 @@ func handleSetPayload(peer *peers.Peer, m peers.CTRLMessage, rawArgs json.RawMes
 
 // handlemark handles mark control messages.
 func handleMark(peer *peers.Peer, m peers.CTRLMessage) {
-	markerM.Lock()
-	lastMarker++
-	peer.Marker = lastMarker
-	markerM.Unlock()
+	peer.Marker = peers.NewMarker()
 	for _, pane := range peers.Panes.All() {
 		pane.Buffer.Mark(peer.Marker)
 	}
@@ -141,6 +326,25 @@ func handleAddPane(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
 		return
 	}
 	Logger.Infof("got add_pane: %v", a)
+	if a.Profile != "" {
+		err = applyProfile(&a)
+		if err != nil {
+			Logger.Warnf("Failed to apply profile: %s", err)
+			peer.SendNack(m, err.Error())
+			return
+		}
+	}
+	if len(a.Command) == 0 {
+		Logger.Warn("Got an add_pane command with no command")
+		peer.SendNack(m, "Missing command")
+		return
+	}
+	restart, err := peers.NewRestartPolicy(a.Restart, a.MaxRestarts, a.RestartDelay)
+	if err != nil {
+		Logger.Warnf("Got an add_pane command with a bad restart policy: %s", err)
+		peer.SendNack(m, err.Error())
+		return
+	}
 	if a.Rows > 0 && a.Cols > 0 {
 		ws = &pty.Winsize{Rows: a.Rows, Cols: a.Cols, X: a.X, Y: a.Y}
 	} else {
@@ -148,28 +352,37 @@ func handleAddPane(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
 		Logger.Warn("Got an add_pane commenad with no rows or cols")
 	}
 
-	if a.Command[0] == "*" {
-		shell, err := loginshell.Shell()
-		if err != nil {
-			Logger.Warnf("Failed to determine user's shell: %v", err)
-			a.Command[0] = "/bin/bash"
-		} else {
-			Logger.Infof("Using %s for shell", shell)
-			a.Command[0] = shell
-		}
-	}
-	cmd := a.Command
-	pane, err := peers.NewPane(peer, ws, a.Parent)
+	cmd := resolveShell(a.Command)
+	pane, err := peers.NewPane(peer.Conf, ws, a.Parent)
 	if err != nil {
 		Logger.Warnf("Failed to add a new pane: %v", err)
 		return
 	}
+	pane.Cwd = a.Cwd
+	pane.Env = a.Env
+	pane.EnvUnset = a.EnvUnset
+	pane.Restart = restart
+	err = pane.AddTriggers(a.Triggers)
+	if err != nil {
+		Logger.Warnf("Got an add_pane command with a bad trigger: %s", err)
+		peer.SendNack(m, err.Error())
+		peers.Panes.Delete(pane.ID)
+		return
+	}
+	err = forwardSockets(peer, pane, a.Sockets)
+	if err != nil {
+		Logger.Warnf("Failed to forward the pane's sockets: %s", err)
+		peer.SendNack(m, err.Error())
+		peers.Panes.Delete(pane.ID)
+		return
+	}
 	l := fmt.Sprintf("%d:%d", m.Ref, pane.ID)
 	d, err := peer.PC.CreateDataChannel(l, dcOpts)
 	if err != nil {
 		msg := fmt.Sprintf("Failed to create data channel : %s", l)
 		peer.SendNack(m, msg)
 		Logger.Warnf(msg)
+		closePaneListeners(pane)
 		return
 	}
 	d.OnOpen(func() {
@@ -181,7 +394,14 @@ func handleAddPane(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
 				Logger.Warnf("Failed to send welcome message: %v", err)
 			}
 		}
-		pane.Run(cmd)
+		err = pane.Run(cmd)
+		if err != nil {
+			peer.SendNack(m, fmt.Sprintf("Failed to run command: %s", err))
+			peers.Panes.Delete(pane.ID)
+			closePaneListeners(pane)
+			d.Close()
+			return
+		}
 		c := peers.CDB.Add(d, pane, peer)
 		Logger.Infof("opened data channel for pane %d", pane.ID)
 		peer.SendAck(m, fmt.Sprintf("%d", pane.ID))
@@ -194,3 +414,213 @@ func handleAddPane(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
 		})
 	})
 }
+
+// handleForwardPort handles forward_port control messages. The agent connects
+// to the target and pipes the connection over a new data channel
+func handleForwardPort(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
+	var a peers.ForwardPortArgs
+	err := json.Unmarshal(raw, &a)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	if a.Host == "" {
+		a.Host = "localhost"
+	}
+	if a.Port < 1 || a.Port > 65535 {
+		peer.SendNack(m, fmt.Sprintf("Bad port: %d", a.Port))
+		return
+	}
+	target := net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+	if !destAllowed(Conf.forwardAllow, a.Host, a.Port) {
+		Logger.Warnf("Got a forward_port to a destination not in the allowlist: %s", target)
+		peer.SendNack(m, fmt.Sprintf("Destination not allowed: %s", target))
+		return
+	}
+	// dialing can take a while so it's done in the background
+	go func() {
+		conn, err := net.DialTimeout("tcp", target, forwardDialTimeout)
+		if err != nil {
+			Logger.Infof("Failed to connect to %s: %s", target, err)
+			peer.SendNack(m, fmt.Sprintf("Failed to connect to %s: %s", target, err))
+			return
+		}
+		f := newForward("local", peer.FP, target, conn)
+		t := true
+		l := fmt.Sprintf("forward:%d:%d", m.Ref, f.id)
+		d, err := peer.PC.CreateDataChannel(l, &webrtc.DataChannelInit{Ordered: &t})
+		if err != nil {
+			msg := fmt.Sprintf("Failed to create data channel : %s", l)
+			peer.SendNack(m, msg)
+			Logger.Warnf(msg)
+			f.close(nil)
+			return
+		}
+		d.OnClose(func() {
+			f.close(d)
+		})
+		d.OnOpen(func() {
+			Logger.Infof("forward #%d: forwarding to %s", f.id, target)
+			peer.SendAck(m, fmt.Sprintf("%d", f.id))
+			f.pipe(d)
+		})
+	}()
+}
+
+// handleListenPort handles listen_port control messages. The agent listens on
+// the host and forwards accepted connections to the peer
+func handleListenPort(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
+	var a peers.ListenPortArgs
+	err := json.Unmarshal(raw, &a)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	if a.Host == "" {
+		a.Host = "127.0.0.1"
+	}
+	if a.Port < 0 || a.Port > 65535 {
+		peer.SendNack(m, fmt.Sprintf("Bad port: %d", a.Port))
+		return
+	}
+	if !listenAllowed(a.Host) {
+		Logger.Warnf("Got a listen_port on a public address: %s", a.Host)
+		peer.SendNack(m, fmt.Sprintf("Listening on %s is not allowed", a.Host))
+		return
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(a.Host, strconv.Itoa(a.Port)))
+	if err != nil {
+		Logger.Infof("Failed to listen: %s", err)
+		peer.SendNack(m, fmt.Sprintf("Failed to listen: %s", err))
+		return
+	}
+	l := newListener(peer, ln)
+	Logger.Infof("listener #%d: listening on %s", l.id, ln.Addr())
+	b, err := json.Marshal(peers.ListenerInfo{ID: l.id, Address: ln.Addr().String(), Port: l.Port()})
+	if err != nil {
+		Logger.Errorf("Failed to marshal listener info: %s", err)
+		peer.SendNack(m, "Failed to marshal listener info")
+		l.close()
+		return
+	}
+	peer.SendAck(m, string(b))
+}
+
+// handleUnlistenPort handles unlisten_port control messages
+func handleUnlistenPort(peer *peers.Peer, m peers.CTRLMessage, raw json.RawMessage) {
+	var a peers.UnlistenPortArgs
+	err := json.Unmarshal(raw, &a)
+	if err != nil {
+		Logger.Infof("Failed to parse incoming control message: %v", err)
+		return
+	}
+	listenersM.Lock()
+	l := listeners[a.ID]
+	listenersM.Unlock()
+	if l == nil || l.pane != nil || l.peer.FP != peer.FP {
+		peer.SendNack(m, fmt.Sprintf("Unknown listener id: %d", a.ID))
+		return
+	}
+	l.close()
+	peer.SendAck(m, "")
+}
+
+// resolveShell replaces a "*" command with the user's login shell
+func resolveShell(command []string) []string {
+	if command[0] != "*" {
+		return command
+	}
+	shell, err := loginshell.Shell()
+	if err != nil {
+		Logger.Warnf("Failed to determine user's shell: %v", err)
+		shell = "/bin/bash"
+	} else {
+		Logger.Infof("Using %s for shell", shell)
+	}
+	return append([]string{shell}, command[1:]...)
+}
+
+// applyProfile fills the missing add_pane arguments from a profile
+func applyProfile(a *peers.AddPaneArgs) error {
+	p, ok := Conf.profiles[a.Profile]
+	if !ok {
+		return fmt.Errorf("Unknown profile: %q", a.Profile)
+	}
+	if len(a.Command) == 0 {
+		a.Command = append([]string{}, p.Command...)
+	}
+	if a.Cwd == "" {
+		a.Cwd = p.Cwd
+	}
+	if a.Rows == 0 && a.Cols == 0 {
+		a.Rows = p.Rows
+		a.Cols = p.Cols
+	}
+	if a.Restart == "" {
+		a.Restart = p.Restart
+		a.MaxRestarts = p.MaxRestarts
+		a.RestartDelay = p.RestartDelay
+	}
+	env := make(map[string]string)
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	for k, v := range a.Env {
+		env[k] = v
+	}
+	a.Env = env
+	a.Triggers = append(append([]peers.Trigger{}, p.Triggers...), a.Triggers...)
+	a.Sockets = mergeSockets(p.Sockets, a.Sockets)
+	return nil
+}
+
+// handleListPanes handles list_panes control messages.
+func handleListPanes(peer *peers.Peer, m peers.CTRLMessage) {
+	b, err := json.Marshal(peers.Panes.List())
+	if err != nil {
+		Logger.Errorf("Failed to marshal panes: %s", err)
+		peer.SendNack(m, "Failed to marshal panes")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send list_panes ack: %v", peer.FP, err)
+	}
+}
+
+// handleListPorts handles list_ports control messages.
+func handleListPorts(peer *peers.Peer, m peers.CTRLMessage) {
+	b, err := json.Marshal(peers.ListPorts())
+	if err != nil {
+		Logger.Errorf("Failed to marshal ports: %s", err)
+		peer.SendNack(m, "Failed to marshal ports")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send list_ports ack: %v", peer.FP, err)
+	}
+}
+
+// handleListProfiles handles list_profiles control messages.
+func handleListProfiles(peer *peers.Peer, m peers.CTRLMessage) {
+	names := make([]string, 0, len(Conf.profiles))
+	for name := range Conf.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	profiles := make([]*Profile, 0, len(names))
+	for _, name := range names {
+		profiles = append(profiles, Conf.profiles[name])
+	}
+	b, err := json.Marshal(profiles)
+	if err != nil {
+		Logger.Errorf("Failed to marshal profiles: %s", err)
+		peer.SendNack(m, "Failed to marshal profiles")
+		return
+	}
+	err = peer.SendAck(m, string(b))
+	if err != nil {
+		Logger.Errorf("#%d: Failed to send list_profiles ack: %v", peer.FP, err)
+	}
+}
@@ -3,12 +3,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -121,6 +124,14 @@ func ParseAck(t *testing.T, msg webrtc.DataChannelMessage) peers.AckArgs {
 	return ackArgs
 }
 
+// isAck returns true if the message is an ack. It is used to skip
+// messages the server sends on its own, i.e. pane_exited
+func isAck(msg webrtc.DataChannelMessage) bool {
+	var cm peers.CTRLMessage
+	err := json.Unmarshal(msg.Data, &cm)
+	return err == nil && cm.Type == "ack"
+}
+
 func TestSimpleEcho(t *testing.T) {
 	initTest(t)
 	Logger.Infof("TestSimpleEcho")
@@ -193,6 +204,9 @@ func TestResizeCommand(t *testing.T) {
 		cdc.Send(addPaneMsg)
 	})
 	cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		if !isAck(msg) {
+			return
+		}
 		ack := ParseAck(t, msg)
 		if ack.Ref == 123 {
 			// parse add_pane and send the resize command
@@ -244,6 +258,9 @@ func TestPayloadOperations(t *testing.T) {
 	cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
 		// we should get an ack for the auth message and the get payload
 		Logger.Infof("Got a ctrl msg: %s", msg.Data)
+		if !isAck(msg) {
+			return
+		}
 		args := ParseAck(t, msg)
 		if args.Ref == 777 {
 			require.Nil(t, err, "Failed to unmarshall the control data channel: %v", err)
@@ -412,6 +429,9 @@ func TestAddPaneMessage(t *testing.T) {
 	cdc.OnOpen(func() {
 		Logger.Info("cdc opened")
 		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if !isAck(msg) {
+				return
+			}
 			ack := ParseAck(t, msg)
 			if ack.Ref == 456 {
 				Logger.Infof("Got the ACK")
@@ -438,6 +458,314 @@ func TestAddPaneMessage(t *testing.T) {
 	case <-done:
 	}
 }
+func TestAddPaneCwdEnv(t *testing.T) {
+	initTest(t)
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	done := make(chan bool)
+	var out strings.Builder
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			out.Write(msg.Data)
+		})
+		d.OnClose(func() {
+			done <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		addPaneArgs := peers.AddPaneArgs{Rows: 12, Cols: 34,
+			Command:  []string{"bash", "-c", "pwd; echo $BADWOLF$DOCTOR"},
+			Cwd:      "/tmp",
+			Env:      map[string]string{"BADWOLF": "Rose", "DOCTOR": "Who"},
+			EnvUnset: []string{"DOCTOR"},
+		}
+		m := peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane",
+			&addPaneArgs}
+		msg, err := json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		time.Sleep(time.Second / 10)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	select {
+	case <-time.After(6 * time.Second):
+		t.Error("Timeout waiting for the pane to close")
+	case <-done:
+	}
+	require.Contains(t, out.String(), "/tmp\r\nRose\r\n")
+}
+func TestProfiles(t *testing.T) {
+	initTest(t)
+	Conf.profiles = map[string]*Profile{
+		"wolf": {Name: "wolf", Command: []string{"bash", "-c", "echo $BADWOLF"},
+			Env: map[string]string{"BADWOLF": "Rose"}},
+	}
+	defer func() { Conf.profiles = nil }()
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	gotList := make(chan string)
+	gotOutput := make(chan bool, 1)
+	closed := make(chan bool)
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if strings.Contains(string(msg.Data), "Rose") {
+				gotOutput <- true
+			}
+		})
+		d.OnClose(func() {
+			closed <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if !isAck(msg) {
+				return
+			}
+			ack := ParseAck(t, msg)
+			if ack.Ref == 455 {
+				gotList <- ack.Body
+			}
+		})
+		time.Sleep(time.Second / 10)
+		m := peers.CTRLMessage{time.Now().UnixNano(), 455, "list_profiles", nil}
+		msg, err := json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		cdc.Send(msg)
+		addPaneArgs := peers.AddPaneArgs{Profile: "wolf"}
+		m = peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane",
+			&addPaneArgs}
+		msg, err = json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	select {
+	case <-time.After(3 * time.Second):
+		t.Error("Timeout waiting for list_profiles ack")
+	case body := <-gotList:
+		var profiles []Profile
+		err = json.Unmarshal([]byte(body), &profiles)
+		require.NoError(t, err)
+		require.Len(t, profiles, 1)
+		require.Equal(t, "wolf", profiles[0].Name)
+		require.Empty(t, profiles[0].Env)
+	}
+	select {
+	case <-time.After(3 * time.Second):
+		t.Error("Timeout waiting for the profile's output")
+	case <-gotOutput:
+	}
+	<-closed
+}
+func TestPaneExited(t *testing.T) {
+	initTest(t)
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	done := make(chan peers.PaneExitedArgs)
+	closed := make(chan bool)
+	paneID := -1
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		d.OnClose(func() {
+			closed <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var args json.RawMessage
+			cm := peers.CTRLMessage{Args: &args}
+			err := json.Unmarshal(msg.Data, &cm)
+			require.Nil(t, err, "failed to unmarshal cdc message: %q", err)
+			switch cm.Type {
+			case "ack":
+				ack := ParseAck(t, msg)
+				if ack.Ref == 456 {
+					paneID, err = strconv.Atoi(ack.Body)
+					require.Nil(t, err)
+				}
+			case "pane_exited":
+				var a peers.PaneExitedArgs
+				err = json.Unmarshal(args, &a)
+				require.Nil(t, err, "failed to unmarshal pane_exited args: %q", err)
+				// ignore panes from previous tests
+				if a.PaneID == paneID {
+					done <- a
+				}
+			}
+		})
+		addPaneArgs := peers.AddPaneArgs{Rows: 12, Cols: 34,
+			Command: []string{"bash", "-c", "sleep 0.5; exit 3"}}
+		m := peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane",
+			&addPaneArgs}
+		msg, err := json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		time.Sleep(time.Second / 10)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	select {
+	case <-time.After(6 * time.Second):
+		t.Error("Timeout waiting for pane_exited")
+	case a := <-done:
+		require.Equal(t, 3, a.ExitCode)
+		require.Empty(t, a.Signal)
+	}
+	<-closed
+}
+func TestPaneRestart(t *testing.T) {
+	initTest(t)
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	restarted := make(chan peers.PaneRestartedArgs, 2)
+	closed := make(chan bool)
+	var output bytes.Buffer
+	var outputM sync.Mutex
+	paneID := -1
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			outputM.Lock()
+			output.Write(msg.Data)
+			outputM.Unlock()
+		})
+		d.OnClose(func() {
+			closed <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var args json.RawMessage
+			cm := peers.CTRLMessage{Args: &args}
+			err := json.Unmarshal(msg.Data, &cm)
+			require.Nil(t, err, "failed to unmarshal cdc message: %q", err)
+			switch cm.Type {
+			case "ack":
+				ack := ParseAck(t, msg)
+				if ack.Ref == 456 {
+					paneID, err = strconv.Atoi(ack.Body)
+					require.Nil(t, err)
+				}
+			case "pane_restarted":
+				var a peers.PaneRestartedArgs
+				err = json.Unmarshal(args, &a)
+				require.Nil(t, err, "failed to unmarshal pane_restarted args: %q", err)
+				if a.PaneID == paneID {
+					restarted <- a
+				}
+			}
+		})
+		addPaneArgs := peers.AddPaneArgs{Rows: 12, Cols: 34,
+			Command:      []string{"bash", "-c", "echo RUNNING; sleep 0.2; exit 1"},
+			Restart:      "on-failure",
+			MaxRestarts:  2,
+			RestartDelay: 100}
+		m := peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane",
+			&addPaneArgs}
+		msg, err := json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		time.Sleep(time.Second / 10)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	for i := 1; i <= 2; i++ {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for restart #%d", i)
+		case a := <-restarted:
+			require.Equal(t, i, a.Restarts)
+		}
+	}
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for the pane to close")
+	case <-closed:
+	}
+	outputM.Lock()
+	defer outputM.Unlock()
+	require.Equal(t, 3, strings.Count(output.String(), "RUNNING"))
+	require.Contains(t, output.String(), "restart #2")
+}
+func TestSignalPane(t *testing.T) {
+	initTest(t)
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	done := make(chan peers.PaneExitedArgs)
+	gotAck := make(chan bool, 1)
+	closed := make(chan bool)
+	paneID := -1
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		d.OnClose(func() {
+			closed <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var args json.RawMessage
+			cm := peers.CTRLMessage{Args: &args}
+			err := json.Unmarshal(msg.Data, &cm)
+			require.Nil(t, err, "failed to unmarshal cdc message: %q", err)
+			switch cm.Type {
+			case "ack":
+				ack := ParseAck(t, msg)
+				if ack.Ref == 456 {
+					paneID, err = strconv.Atoi(ack.Body)
+					require.Nil(t, err)
+					time.Sleep(time.Second / 10)
+					a := peers.SignalPaneArgs{PaneID: paneID, Signal: "TERM"}
+					m := peers.CTRLMessage{time.Now().UnixNano(), 457,
+						"signal_pane", &a}
+					msg, err := json.Marshal(m)
+					require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+					cdc.Send(msg)
+				} else if ack.Ref == 457 {
+					gotAck <- true
+				}
+			case "pane_exited":
+				var a peers.PaneExitedArgs
+				err = json.Unmarshal(args, &a)
+				require.Nil(t, err, "failed to unmarshal pane_exited args: %q", err)
+				if a.PaneID == paneID {
+					done <- a
+				}
+			}
+		})
+		addPaneArgs := peers.AddPaneArgs{Rows: 12, Cols: 34,
+			Command: []string{"sleep", "10"}}
+		m := peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane",
+			&addPaneArgs}
+		msg, err := json.Marshal(m)
+		require.Nil(t, err, "failed marshilng ctrl msg: %v", msg)
+		time.Sleep(time.Second / 10)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	select {
+	case <-time.After(6 * time.Second):
+		t.Error("Timeout waiting for pane_exited")
+	case a := <-done:
+		require.Equal(t, "SIGTERM", a.Signal)
+	}
+	<-gotAck
+	<-closed
+}
 func TestReconnectPane(t *testing.T) {
 	initTest(t)
 	var (
@@ -470,6 +798,9 @@ func TestReconnectPane(t *testing.T) {
 		Logger.Info("cdc opened")
 		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
 			Logger.Infof("cdc got an ack: %v", string(msg.Data))
+			if !isAck(msg) {
+				return
+			}
 			ack := ParseAck(t, msg)
 			if ack.Ref == 456 {
 				ci, err = strconv.Atoi(string(ack.Body))
@@ -503,24 +834,16 @@ func TestReconnectPane(t *testing.T) {
 func TestExecCommand(t *testing.T) {
 	initTest(t)
 	c := []string{"bash", "-c", "echo hello"}
-	_, tty, err := peers.ExecCommand(c, nil, nil, 0, "")
+	_, tty, err := peers.ExecCommand(c, nil, nil, nil, "")
 	b := make([]byte, 64)
 	l, err := tty.Read(b)
 	require.Nil(t, err)
 	require.Less(t, 6, l, "Expected at least 5 bytes %s", string(b))
 	require.Equal(t, "hello", string(b[:5]))
 }
-func TestExecCommandWithParent(t *testing.T) {
+func TestExecCommandWithCwd(t *testing.T) {
 	initTest(t)
-	c := []string{"sh"}
-	cmd, tty, err := peers.ExecCommand(c, nil, nil, 0, "")
-	time.Sleep(time.Second / 100)
-	_, err = tty.Write([]byte("cd /tmp\n"))
-	require.Nil(t, err)
-	_, err = tty.Write([]byte("pwd\n"))
-	require.Nil(t, err)
-	time.Sleep(time.Second / 10)
-	_, tty2, err := peers.ExecCommand([]string{"pwd"}, nil, nil, cmd.Process.Pid, "")
+	_, tty2, err := peers.ExecCommand([]string{"pwd"}, nil, nil, nil, "/tmp")
 	require.Nil(t, err)
 	b := make([]byte, 64)
 	l, err := tty2.Read(b)
@@ -709,3 +1032,433 @@ func TestCopyCommand(t *testing.T) {
 	}
 
 }
+func TestForwardPort(t *testing.T) {
+	initTest(t)
+	// an echo server to forward to
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	echoed := make(chan string, 1)
+	closed := make(chan bool, 1)
+	nacks := make(chan peers.NAckArgs, 2)
+	acks := make(chan peers.AckArgs, 2)
+	channels := make(chan *webrtc.DataChannel, 1)
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		require.True(t, strings.HasPrefix(d.Label(), "forward:123:"), d.Label())
+		channels <- d
+		d.OnOpen(func() {
+			d.Send([]byte("ping"))
+		})
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			echoed <- string(msg.Data)
+		})
+		d.OnClose(func() {
+			closed <- true
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	send := func(ref int, args peers.ForwardPortArgs) {
+		m := peers.CTRLMessage{time.Now().UnixNano(), ref, "forward_port", &args}
+		msg, err := json.Marshal(m)
+		require.NoError(t, err)
+		cdc.Send(msg)
+	}
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var args json.RawMessage
+			cm := peers.CTRLMessage{Args: &args}
+			require.NoError(t, json.Unmarshal(msg.Data, &cm))
+			switch cm.Type {
+			case "ack":
+				acks <- ParseAck(t, msg)
+			case "nack":
+				var a peers.NAckArgs
+				require.NoError(t, json.Unmarshal(args, &a))
+				nacks <- a
+			}
+		})
+		time.Sleep(time.Second / 10)
+		send(122, peers.ForwardPortArgs{Host: "example.com", Port: 80})
+		send(123, peers.ForwardPortArgs{Host: "127.0.0.1", Port: port})
+	})
+	SignalPair(client, peer)
+	select {
+	case n := <-nacks:
+		require.Equal(t, 122, n.Ref)
+		require.Contains(t, n.Desc, "not allowed")
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the nack")
+	}
+	select {
+	case a := <-acks:
+		require.Equal(t, 123, a.Ref)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the ack")
+	}
+	select {
+	case s := <-echoed:
+		require.Equal(t, "ping", s)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the echo")
+	}
+	stats := allForwardStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "local", stats[0].Type)
+	require.Equal(t, fmt.Sprintf("127.0.0.1:%d", port), stats[0].Target)
+	require.EqualValues(t, 4, stats[0].Sent)
+	require.EqualValues(t, 4, stats[0].Received)
+	// closing the data channel closes the forward
+	(<-channels).Close()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the data channel to close")
+	}
+	for i := 0; i < 20 && len(allForwardStats()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Empty(t, allForwardStats())
+}
+func TestListenPort(t *testing.T) {
+	initTest(t)
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	nacks := make(chan peers.NAckArgs, 2)
+	acks := make(chan peers.AckArgs, 2)
+	labels := make(chan string, 1)
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		labels <- d.Label()
+		// echo everything back to the host
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			d.Send(msg.Data)
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	send := func(ref int, typ string, args interface{}) {
+		m := peers.CTRLMessage{time.Now().UnixNano(), ref, typ, args}
+		msg, err := json.Marshal(m)
+		require.NoError(t, err)
+		cdc.Send(msg)
+	}
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			var args json.RawMessage
+			cm := peers.CTRLMessage{Args: &args}
+			require.NoError(t, json.Unmarshal(msg.Data, &cm))
+			switch cm.Type {
+			case "ack":
+				acks <- ParseAck(t, msg)
+			case "nack":
+				var a peers.NAckArgs
+				require.NoError(t, json.Unmarshal(args, &a))
+				nacks <- a
+			}
+		})
+		time.Sleep(time.Second / 10)
+		send(122, "listen_port", &peers.ListenPortArgs{Host: "0.0.0.0", Port: 0})
+		send(123, "listen_port", &peers.ListenPortArgs{Port: 0})
+	})
+	SignalPair(client, peer)
+	select {
+	case n := <-nacks:
+		require.Equal(t, 122, n.Ref)
+		require.Contains(t, n.Desc, "not allowed")
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the nack")
+	}
+	var info peers.ListenerInfo
+	select {
+	case a := <-acks:
+		require.Equal(t, 123, a.Ref)
+		require.NoError(t, json.Unmarshal([]byte(a.Body), &info))
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the ack")
+	}
+	require.NotZero(t, info.Port)
+	require.Len(t, allListenerStats(), 1)
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", info.Port))
+	require.NoError(t, err)
+	defer conn.Close()
+	select {
+	case l := <-labels:
+		require.True(t, strings.HasPrefix(l, fmt.Sprintf("listen:%d:", info.ID)), l)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the data channel")
+	}
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+	stats := allForwardStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "remote", stats[0].Type)
+	// only the listener's peer can close it
+	send(124, "unlisten_port", &peers.UnlistenPortArgs{ID: info.ID + 1})
+	select {
+	case n := <-nacks:
+		require.Equal(t, 124, n.Ref)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the nack")
+	}
+	send(125, "unlisten_port", &peers.UnlistenPortArgs{ID: info.ID})
+	select {
+	case a := <-acks:
+		require.Equal(t, 125, a.Ref)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the ack")
+	}
+	require.Empty(t, allListenerStats())
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", info.Port))
+	require.Error(t, err)
+	// connections accepted before unlisten stay open
+	_, err = conn.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "pong", string(b))
+	// closing the control channel closes the peer's listeners
+	send(126, "listen_port", &peers.ListenPortArgs{Port: 0})
+	select {
+	case a := <-acks:
+		require.Equal(t, 126, a.Ref)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the ack")
+	}
+	require.Len(t, allListenerStats(), 1)
+	cdc.Close()
+	for i := 0; i < 20 && len(allListenerStats()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Empty(t, allListenerStats())
+}
+func TestSOCKS(t *testing.T) {
+	initTest(t)
+	// an echo server to connect to
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	port := l.Addr().(*net.TCPAddr).Port
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	// connect opens a socks channel, sends the request and returns the
+	// channel & the messages received on it
+	connect := func(host string, port int) (*webrtc.DataChannel, chan []byte) {
+		received := make(chan []byte, 8)
+		d, err := client.CreateDataChannel("socks", nil)
+		require.NoError(t, err)
+		d.OnOpen(func() {
+			req := []byte{5, 1, 0, 5, 1, 0, 3, byte(len(host))}
+			req = append(req, host...)
+			req = append(req, byte(port>>8), byte(port))
+			d.Send(req)
+		})
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			received <- msg.Data
+		})
+		d.OnClose(func() {
+			close(received)
+		})
+		return d, received
+	}
+	receive := func(received chan []byte) []byte {
+		select {
+		case b := <-received:
+			return b
+		case <-time.After(3 * time.Second):
+			t.Fatal("Timeout waiting for a message")
+		}
+		return nil
+	}
+	opened := make(chan bool, 1)
+	cdc.OnOpen(func() { opened <- true })
+	SignalPair(client, peer)
+	select {
+	case <-opened:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the control channel")
+	}
+	d, received := connect("localhost", port)
+	require.Equal(t, []byte{5, 0}, receive(received))
+	reply := receive(received)
+	require.Equal(t, []byte{5, socksSucceeded, 0, 1, 127, 0, 0, 1}, reply[:8])
+	d.Send([]byte("ping"))
+	require.Equal(t, "ping", string(receive(received)))
+	stats := allForwardStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "socks", stats[0].Type)
+	require.Equal(t, fmt.Sprintf("localhost:%d", port), stats[0].Target)
+	d.Close()
+	// destinations that are not allowed are refused
+	_, _, err = parseConf("[socks]\nallow = [ \"10.0.0.0/8\" ]\n")
+	require.NoError(t, err)
+	_, received = connect("127.0.0.1", port)
+	require.Equal(t, []byte{5, 0}, receive(received))
+	require.Equal(t, socksNotAllowed, receive(received)[1])
+	select {
+	case _, ok := <-received:
+		require.False(t, ok, "expected the channel to close")
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the channel to close")
+	}
+	for i := 0; i < 20 && len(allForwardStats()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Empty(t, allForwardStats())
+}
+func TestSocketForward(t *testing.T) {
+	initTest(t)
+	socketsDir = t.TempDir()
+	defer func() { socketsDir = "" }()
+	client, certs, err := NewClient(true)
+	require.Nil(t, err, "Failed to create a new client %v", err)
+	defer client.Close()
+	peer := newPeer(t, "A", certs)
+	// the peer logs when its control channel closes, wait for it so it
+	// doesn't log after the test
+	ctrlClosed := make(chan bool, 1)
+	peer.Conf.OnCTRLClose = func(p *peers.Peer) {
+		handleCTRLClose(p)
+		ctrlClosed <- true
+	}
+	output := make(chan string, 16)
+	labels := make(chan string, 1)
+	acks := make(chan peers.AckArgs, 1)
+	client.OnDataChannel(func(d *webrtc.DataChannel) {
+		if !strings.HasPrefix(d.Label(), "socket:") {
+			d.OnMessage(func(msg webrtc.DataChannelMessage) {
+				output <- string(msg.Data)
+			})
+			return
+		}
+		labels <- d.Label()
+		// act as the client's agent, echoing the requests
+		d.OnMessage(func(msg webrtc.DataChannelMessage) {
+			d.Send(msg.Data)
+		})
+	})
+	cdc, err := client.CreateDataChannel("%", nil)
+	require.Nil(t, err, "failed to create the control data channel: %v", err)
+	cdc.OnOpen(func() {
+		cdc.OnMessage(func(msg webrtc.DataChannelMessage) {
+			if isAck(msg) {
+				acks <- ParseAck(t, msg)
+			}
+		})
+		addPaneArgs := peers.AddPaneArgs{Rows: 24, Cols: 200,
+			Command: []string{"sh", "-c", "echo SOCK=$SSH_AUTH_SOCK; sleep 10"},
+			Sockets: []peers.SocketForward{{Name: "ssh-agent"}}}
+		m := peers.CTRLMessage{time.Now().UnixNano(), 456, "add_pane", &addPaneArgs}
+		msg, err := json.Marshal(m)
+		require.NoError(t, err)
+		time.Sleep(time.Second / 10)
+		cdc.Send(msg)
+	})
+	SignalPair(client, peer)
+	var paneID int
+	select {
+	case a := <-acks:
+		require.Equal(t, 456, a.Ref)
+		paneID, err = strconv.Atoi(a.Body)
+		require.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the ack")
+	}
+	var out string
+	for !strings.Contains(out, ".sock") {
+		select {
+		case s := <-output:
+			out += s
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timeout waiting for the socket's path, got: %q", out)
+		}
+	}
+	path := strings.TrimSpace(out[strings.Index(out, "SOCK=")+5:])
+	require.True(t, strings.HasSuffix(path, "/ssh-agent.sock"), path)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.ModeSocket|0600, fi.Mode())
+	fi, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Equal(t, os.ModeDir|0700, fi.Mode())
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	select {
+	case l := <-labels:
+		require.True(t, strings.HasPrefix(l, "socket:ssh-agent:"), l)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the data channel")
+	}
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+	stats := allForwardStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, "socket", stats[0].Type)
+	conn.Close()
+	for i := 0; i < 20 && len(allForwardStats()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Empty(t, allForwardStats())
+	// the socket is removed with the pane
+	peers.Panes.Get(paneID).Kill()
+	// so the read loop doesn't kill it again after the test
+	peers.Panes.Delete(paneID)
+	for i := 0; i < 20 && len(allListenerStats()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Empty(t, allListenerStats())
+	_, err = os.Stat(filepath.Dir(path))
+	require.True(t, os.IsNotExist(err), "the socket's directory was not removed")
+	client.Close()
+	select {
+	case <-ctrlClosed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the control channel to close")
+	}
+	// the read loop stops the pane's sender a bit after the tty is closed
+	time.Sleep(time.Second / 2)
+}
@@ -0,0 +1,218 @@
+// This file holds the code that listens on the host for connections that are
+// forwarded to the peers
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/tuzig/webexec/peers"
+)
+
+// ListenerStats holds the details of a listener
+type ListenerStats struct {
+	ID      int    `json:"id"`
+	FP      string `json:"fp"`
+	Address string `json:"address"`
+	Created int64  `json:"created"`
+}
+
+// Write writes the stats as a row in a status table
+func (s *ListenerStats) Write(w *tabwriter.Writer, now time.Time) {
+	age := now.Sub(time.UnixMilli(s.Created)).Round(time.Second)
+	fmt.Fprintf(w, "  %d\t%s\t%s\t%s\n", s.ID, shortFP(s.FP), s.Address, age)
+}
+
+// Listener listens on a host's port and forwards accepted connections to a
+// peer
+type Listener struct {
+	id   int
+	peer *peers.Peer
+	// pane & socket are set for unix sockets forwarded from a pane. Their
+	// connections go to the last peer attached to the pane
+	pane    *peers.Pane
+	socket  string
+	ln      net.Listener
+	created time.Time
+	once    sync.Once
+	closed  chan struct{}
+}
+
+var (
+	listeners      = map[int]*Listener{}
+	listenersM     sync.Mutex
+	lastListenerID int
+)
+
+// listenAllowed returns true if the agent can listen on the host. Unless
+// forward.listen_public is set, only loopback addresses are allowed
+func listenAllowed(host string) bool {
+	if Conf.listenPublic || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// newListener adds a listener to the listeners' db and starts accepting
+// connections
+func newListener(peer *peers.Peer, ln net.Listener) *Listener {
+	return addListener(&Listener{peer: peer, ln: ln})
+}
+
+// addListener adds a listener to the listeners' db and starts accepting
+// connections
+func addListener(l *Listener) *Listener {
+	listenersM.Lock()
+	lastListenerID++
+	l.id = lastListenerID
+	l.created = time.Now()
+	l.closed = make(chan struct{})
+	listeners[l.id] = l
+	listenersM.Unlock()
+	go l.serve()
+	return l
+}
+
+// Port returns the port the listener is bound to
+func (l *Listener) Port() int {
+	return l.ln.Addr().(*net.TCPAddr).Port
+}
+
+// Stats returns the listener's details
+func (l *Listener) Stats() ListenerStats {
+	s := ListenerStats{
+		ID:      l.id,
+		Address: l.ln.Addr().String(),
+		Created: l.created.UnixMilli(),
+	}
+	if l.peer != nil {
+		s.FP = l.peer.FP
+	}
+	return s
+}
+
+// allListenerStats returns the details of all the listeners, sorted by id
+func allListenerStats() []ListenerStats {
+	listenersM.Lock()
+	ret := make([]ListenerStats, 0, len(listeners))
+	for _, l := range listeners {
+		ret = append(ret, l.Stats())
+	}
+	listenersM.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// serve accepts connections until the listener is closed
+func (l *Listener) serve() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			Logger.Infof("listener #%d stopped accepting: %s", l.id, err)
+			l.close()
+			return
+		}
+		l.forward(conn)
+	}
+}
+
+// forward opens a data channel to the peer and pipes the connection over it.
+// The peer connects to the target on its side when the channel is opened and
+// closes the channel if it fails.
+func (l *Listener) forward(conn net.Conn) {
+	peer := l.peer
+	if l.pane != nil {
+		peer = l.pane.LastPeer()
+	}
+	if peer == nil || peer.PC == nil {
+		Logger.Infof("listener #%d: no peer to forward a connection to", l.id)
+		conn.Close()
+		return
+	}
+	typ := "remote"
+	if l.socket != "" {
+		typ = "socket"
+	}
+	f := newForward(typ, peer.FP, l.ln.Addr().String(), conn)
+	t := true
+	label := fmt.Sprintf("listen:%d:%d", l.id, f.id)
+	if l.socket != "" {
+		label = fmt.Sprintf("socket:%s:%d", l.socket, f.id)
+	}
+	d, err := peer.PC.CreateDataChannel(label, &webrtc.DataChannelInit{Ordered: &t})
+	if err != nil {
+		Logger.Warnf("Failed to create data channel : %s", label)
+		f.close(nil)
+		return
+	}
+	d.OnClose(func() {
+		f.close(d)
+	})
+	d.OnOpen(func() {
+		Logger.Infof("forward #%d: forwarding a connection from %s", f.id, conn.RemoteAddr())
+		f.pipe(d)
+	})
+	// if the peer is gone the channel might never open or close
+	time.AfterFunc(forwardDialTimeout, func() {
+		if d.ReadyState() == webrtc.DataChannelStateConnecting {
+			Logger.Infof("forward #%d: the data channel didn't open", f.id)
+			f.close(d)
+		}
+	})
+}
+
+// close stops listening and removes the listener. Connections that were
+// already accepted are left open
+func (l *Listener) close() {
+	l.once.Do(func() {
+		close(l.closed)
+		l.ln.Close()
+		if l.socket != "" {
+			// the socket's private directory
+			os.RemoveAll(filepath.Dir(l.ln.Addr().String()))
+		}
+		listenersM.Lock()
+		delete(listeners, l.id)
+		listenersM.Unlock()
+		Logger.Infof("listener #%d on %s closed", l.id, l.ln.Addr())
+	})
+}
+
+// closeListeners closes all the listeners of a peer, except for the panes'
+// sockets that stay open until their pane is killed
+func closeListeners(peer *peers.Peer) {
+	listenersM.Lock()
+	var ls []*Listener
+	for _, l := range listeners {
+		if l.peer == peer && l.pane == nil {
+			ls = append(ls, l)
+		}
+	}
+	listenersM.Unlock()
+	for _, l := range ls {
+		l.close()
+	}
+}
+
+// closePaneListeners closes all the unix sockets forwarded from a pane
+func closePaneListeners(pane *peers.Pane) {
+	listenersM.Lock()
+	var ls []*Listener
+	for _, l := range listeners {
+		if l.pane == pane {
+			ls = append(ls, l)
+		}
+	}
+	listenersM.Unlock()
+	for _, l := range ls {
+		l.close()
+	}
+}
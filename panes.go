@@ -0,0 +1,519 @@
+// This file holds the commands used to control the agent's panes from the
+// command line
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+	"github.com/tuzig/webexec/peers"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// ExpectRequest is the body of a `POST /panes/{id}/expect` request
+type ExpectRequest struct {
+	Steps []peers.ExpectStep `json:"steps"`
+	// Timeout is the default timeout of the steps, in msec
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// SendKeysRequest is the body of a `POST /panes/{id}/keys` request
+type SendKeysRequest struct {
+	Keys []string `json:"keys"`
+	// Literal is true when key names should be sent as text
+	Literal bool `json:"literal,omitempty"`
+}
+
+// PaneDetails is the reply to `GET /panes/{id}`
+type PaneDetails struct {
+	peers.PaneInfo
+	Process *peers.PaneProcessInfo `json:"process,omitempty"`
+}
+
+// PanePatchRequest is the body of a `PATCH /panes/{id}` request
+type PanePatchRequest struct {
+	Name *string `json:"name"`
+}
+
+// PaneCommands are the sub commands of `webexec panes`
+var PaneCommands = []*cli.Command{
+	{
+		Name:  "list",
+		Usage: "list the panes",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "print the panes as json",
+			},
+		},
+		Action: listPanesCMD,
+	}, {
+		Name:      "info",
+		Usage:     "print a pane's details & its process' details",
+		ArgsUsage: "PANE_ID",
+		Action:    paneInfoCMD,
+	}, {
+		Name:      "kill",
+		Usage:     "kill one or more panes",
+		ArgsUsage: "PANE_ID...",
+		Action:    killPanesCMD,
+	}, {
+		Name:      "rename",
+		Usage:     "set a pane's name",
+		ArgsUsage: "PANE_ID NAME",
+		Action:    renamePaneCMD,
+	},
+}
+
+// agentClient returns an http client for the agent's socket
+func agentClient() (*http.Client, error) {
+	httpc := newSocketClient()
+	if httpc == nil {
+		return nil, fmt.Errorf("Agent is not running. Please run `webexec start`")
+	}
+	return httpc, nil
+}
+
+// agentRequest sends a request to the agent and returns the response's body.
+// It fails if the response's status isn't a success
+func agentRequest(method string, path string, body []byte) ([]byte, error) {
+	httpc, err := agentClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, "http://unix"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to communicate with agent: %s", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the agent's response: %s", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+// paneID parses the pane id argument
+func paneID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, fmt.Errorf("Bad pane id: %q", arg)
+	}
+	return id, nil
+}
+
+// idleTime returns how long the pane had no output, in seconds
+func idleTime(info *peers.PaneInfo, now time.Time) time.Duration {
+	last := info.LastOutput
+	if last == 0 {
+		last = info.Created
+	}
+	return now.Sub(time.UnixMilli(last)).Round(time.Second)
+}
+
+// listPanesCMD prints a table of the panes
+func listPanesCMD(c *cli.Context) error {
+	b, err := agentRequest("GET", "/panes", nil)
+	if err != nil {
+		return fmt.Errorf("Failed to list the panes: %s", err)
+	}
+	if c.Bool("json") {
+		fmt.Println(string(b))
+		return nil
+	}
+	var panes []peers.PaneInfo
+	err = json.Unmarshal(b, &panes)
+	if err != nil {
+		return fmt.Errorf("Failed to decode the agent's response: %s", err)
+	}
+	if len(panes) == 0 {
+		fmt.Println("No panes")
+		return nil
+	}
+	header := color.New(color.FgYellow).FprintfFunc()
+	w := tabwriter.NewWriter(os.Stdout, 0, 3, 2, ' ', 0)
+	header(w, "ID\tNAME\tCOMMAND\tSIZE\tSTATE\tIDLE\tPEERS\n")
+	now := time.Now()
+	for _, p := range panes {
+		state := "exited"
+		if p.Running {
+			state = "running"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%dx%d\t%s\t%s\t%s\n", p.ID, p.Name,
+			strings.Join(p.Command, " "), p.Cols, p.Rows, state,
+			idleTime(&p, now), strings.Join(p.Peers, ","))
+	}
+	w.Flush()
+	return nil
+}
+
+// paneInfoCMD prints the details of a pane
+func paneInfoCMD(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Usage: webexec panes info PANE_ID")
+	}
+	id, err := paneID(c.Args().First())
+	if err != nil {
+		return err
+	}
+	b, err := agentRequest("GET", fmt.Sprintf("/panes/%d", id), nil)
+	if err != nil {
+		return fmt.Errorf("Failed to get the pane's info: %s", err)
+	}
+	var d PaneDetails
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		return fmt.Errorf("Failed to decode the agent's response: %s", err)
+	}
+	label := color.New().PrintfFunc()
+	value := color.New(color.FgGreen).PrintfFunc()
+	field := func(name string, format string, args ...interface{}) {
+		label("%-12s", name+":")
+		value(format+"\n", args...)
+	}
+	field("ID", "%d", d.ID)
+	if d.Name != "" {
+		field("Name", "%s", d.Name)
+	}
+	field("Command", "%s", strings.Join(d.Command, " "))
+	if d.Title != "" {
+		field("Title", "%s", d.Title)
+	}
+	field("Size", "%dx%d", d.Cols, d.Rows)
+	field("Running", "%t", d.Running)
+	field("Created", "%s", time.UnixMilli(d.Created).Format(time.RFC3339))
+	field("Idle", "%s", idleTime(&d.PaneInfo, time.Now()))
+	if d.Parent != 0 {
+		field("Parent", "%d", d.Parent)
+	}
+	field("Peers", "%s", strings.Join(d.Peers, ", "))
+	if d.Process != nil {
+		field("Foreground", "%s (%d)", d.Process.Foreground, d.Process.ForegroundPID)
+		field("Cwd", "%s", d.Process.Cwd)
+		field("CPU", "%.1f%%", d.Process.CPU)
+		field("RSS", "%d KB", d.Process.RSS/1024)
+	}
+	return nil
+}
+
+// killPanesCMD kills panes
+func killPanesCMD(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("Usage: webexec panes kill PANE_ID...")
+	}
+	for _, arg := range c.Args().Slice() {
+		id, err := paneID(arg)
+		if err != nil {
+			return err
+		}
+		_, err = agentRequest("DELETE", fmt.Sprintf("/panes/%d", id), nil)
+		if err != nil {
+			return fmt.Errorf("Failed to kill pane %d: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// renamePaneCMD sets a pane's name
+func renamePaneCMD(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("Usage: webexec panes rename PANE_ID NAME")
+	}
+	id, err := paneID(c.Args().First())
+	if err != nil {
+		return err
+	}
+	name := c.Args().Get(1)
+	b, err := json.Marshal(PanePatchRequest{Name: &name})
+	if err != nil {
+		return err
+	}
+	_, err = agentRequest("PATCH", fmt.Sprintf("/panes/%d", id), b)
+	if err != nil {
+		return fmt.Errorf("Failed to rename pane %d: %s", id, err)
+	}
+	return nil
+}
+
+// unescape replaces the common backslash escapes, like `\n`, in s
+func unescape(s string) string {
+	r := strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\e`, "\x1b", `\\`, `\`)
+	return r.Replace(s)
+}
+
+// parseExpectScript parses the expect command's arguments, pairs of `send`
+// or `expect` and a value
+func parseExpectScript(args []string) ([]peers.ExpectStep, error) {
+	var steps []peers.ExpectStep
+	if len(args)%2 != 0 {
+		return nil, fmt.Errorf("steps should be pairs of `send TEXT` or `expect REGEX`")
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch args[i] {
+		case "send":
+			steps = append(steps, peers.ExpectStep{Send: unescape(args[i+1])})
+		case "expect":
+			steps = append(steps, peers.ExpectStep{Expect: args[i+1]})
+		default:
+			return nil, fmt.Errorf("unknown step: %q", args[i])
+		}
+	}
+	return steps, nil
+}
+
+// expectCMD runs an expect script against a pane and prints the result
+func expectCMD(c *cli.Context) error {
+	if c.NArg() < 3 {
+		return fmt.Errorf("Usage: webexec expect PANE_ID [send TEXT | expect REGEX]...")
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("Bad pane id: %q", c.Args().First())
+	}
+	steps, err := parseExpectScript(c.Args().Tail())
+	if err != nil {
+		return err
+	}
+	httpc, err := agentClient()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(ExpectRequest{Steps: steps, Timeout: c.Int("timeout")})
+	if err != nil {
+		return err
+	}
+	resp, err := httpc.Post(fmt.Sprintf("http://unix/panes/%d/expect", id),
+		"application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("Failed to communicate with agent: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read the agent's response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to run the script: %s: %s", resp.Status, body)
+	}
+	fmt.Println(string(body))
+	var result peers.ExpectResult
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return fmt.Errorf("Failed to decode the agent's response: %s", err)
+	}
+	if !result.OK {
+		return cli.Exit(fmt.Sprintf("step %d failed: %s", result.Step, result.Error), 1)
+	}
+	return nil
+}
+
+// sendKeysCMD sends keys to a pane
+func sendKeysCMD(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return fmt.Errorf("Usage: webexec send-keys PANE_ID KEY...")
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("Bad pane id: %q", c.Args().First())
+	}
+	httpc, err := agentClient()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(SendKeysRequest{Keys: c.Args().Tail(), Literal: c.Bool("literal")})
+	if err != nil {
+		return err
+	}
+	resp, err := httpc.Post(fmt.Sprintf("http://unix/panes/%d/keys", id),
+		"application/json", bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("Failed to communicate with agent: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Failed to send keys: %s: %s", resp.Status, body)
+	}
+	return nil
+}
+
+// capturePaneCMD prints a pane's screen or the last bytes of its output
+func capturePaneCMD(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Usage: webexec capture-pane [--screen | --history N] PANE_ID")
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("Bad pane id: %q", c.Args().First())
+	}
+	if c.IsSet("history") && c.Bool("screen") {
+		return fmt.Errorf("Use either --screen or --history")
+	}
+	q := url.Values{}
+	if c.IsSet("history") {
+		if c.Bool("json") {
+			return fmt.Errorf("--json can only be used with the screen")
+		}
+		q.Set("history", strconv.Itoa(c.Int("history")))
+	}
+	if c.Bool("escapes") {
+		q.Set("escapes", "true")
+	}
+	if c.Bool("json") {
+		q.Set("format", "json")
+	}
+	httpc, err := agentClient()
+	if err != nil {
+		return err
+	}
+	resp, err := httpc.Get(fmt.Sprintf("http://unix/panes/%d/capture?%s", id, q.Encode()))
+	if err != nil {
+		return fmt.Errorf("Failed to communicate with agent: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read the agent's response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to capture the pane: %s: %s", resp.Status, body)
+	}
+	os.Stdout.Write(body)
+	return nil
+}
+
+// attachCMD attaches the terminal to a pane until the pane ends or the user
+// types the detach key
+func attachCMD(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("Usage: webexec attach [--detach-key KEY] PANE_ID")
+	}
+	id, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return fmt.Errorf("Bad pane id: %q", c.Args().First())
+	}
+	detachKey := peers.KeysInput([]string{c.String("detach-key")}, false, false)
+	if len(detachKey) != 1 {
+		return fmt.Errorf("The detach key should be a single key, like C-\\ or C-q")
+	}
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("attach needs a terminal")
+	}
+	if _, err := agentClient(); err != nil {
+		return err
+	}
+	cols, rows, err := term.GetSize(fd)
+	if err != nil {
+		return fmt.Errorf("Failed to get the terminal's size: %s", err)
+	}
+	dialer := websocket.Dialer{
+		NetDial: func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", GetSockFP())
+		},
+	}
+	conn, resp, err := dialer.Dial(
+		fmt.Sprintf("ws://unix/panes/%d/attach?rows=%d&cols=%d", id, rows, cols), nil)
+	if err != nil {
+		if resp != nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("Failed to attach: %s: %s", resp.Status, body)
+		}
+		return fmt.Errorf("Failed to communicate with agent: %s", err)
+	}
+	defer conn.Close()
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("Failed to set the terminal to raw mode: %s", err)
+	}
+	var wm sync.Mutex
+	write := func(mt int, b []byte) error {
+		wm.Lock()
+		defer wm.Unlock()
+		return conn.WriteMessage(mt, b)
+	}
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			cols, rows, err := term.GetSize(fd)
+			if err != nil {
+				continue
+			}
+			b, _ := json.Marshal(AttachResize{Rows: uint16(rows), Cols: uint16(cols)})
+			write(websocket.TextMessage, b)
+		}
+	}()
+	detached := make(chan struct{})
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			b := buf[:n]
+			i := bytes.IndexByte(b, detachKey[0])
+			if i >= 0 {
+				b = b[:i]
+			}
+			if len(b) > 0 && write(websocket.BinaryMessage, b) != nil {
+				return
+			}
+			if i >= 0 {
+				close(detached)
+				return
+			}
+		}
+	}()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, b, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			os.Stdout.Write(b)
+		}
+	}()
+	var msg string
+	select {
+	case <-detached:
+		write(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		msg = fmt.Sprintf("[detached from pane %d]", id)
+	case <-closed:
+		msg = fmt.Sprintf("[pane %d closed]", id)
+	}
+	term.Restore(fd, oldState)
+	fmt.Printf("\r\n%s\n", msg)
+	return nil
+}
@@ -0,0 +1,81 @@
+// This file holds the code that removes escape sequences from the panes'
+// output
+package peers
+
+import "bytes"
+
+// escLen returns the length of the escape sequence at the start of b or -1
+// if the sequence is incomplete
+func escLen(b []byte) int {
+	if len(b) < 2 {
+		return -1
+	}
+	switch b[1] {
+	case '[':
+		// CSI, ends with a byte in the 0x40-0x7e range
+		for i := 2; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return -1
+	case ']', 'P', '_', '^':
+		// string sequences end with BEL or ST
+		end, l := oscEnd(b[2:])
+		if end == -1 {
+			return -1
+		}
+		return end + l + 2
+	case '(', ')', '*', '+':
+		// character set designation has one more byte
+		if len(b) < 3 {
+			return -1
+		}
+		return 3
+	}
+	return 2
+}
+
+// StripANSI removes escape sequences from b. An incomplete sequence at the
+// end of b is removed as well
+func StripANSI(b []byte) []byte {
+	ret, _ := stripANSI(b)
+	return ret
+}
+
+// stripANSI removes escape sequences from b and returns the offset of an
+// incomplete sequence at the end of b, or len(b) if there isn't one
+func stripANSI(b []byte) ([]byte, int) {
+	var ret bytes.Buffer
+	for i := 0; i < len(b); i++ {
+		if b[i] != '\x1b' {
+			ret.WriteByte(b[i])
+			continue
+		}
+		l := escLen(b[i:])
+		if l == -1 {
+			return ret.Bytes(), i
+		}
+		i += l - 1
+	}
+	return ret.Bytes(), len(b)
+}
+
+// ansiStripper removes escape sequences from a stream. A sequence split
+// between writes is kept until it's complete
+type ansiStripper struct {
+	carry []byte
+}
+
+// strip returns b without escape sequences
+func (s *ansiStripper) strip(b []byte) []byte {
+	if len(s.carry) > 0 {
+		b = append(s.carry, b...)
+		s.carry = nil
+	}
+	ret, rest := stripANSI(b)
+	if rest < len(b) && len(b)-rest < maxOSCLen {
+		s.carry = append([]byte{}, b[rest:]...)
+	}
+	return ret
+}
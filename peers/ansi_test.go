@@ -0,0 +1,18 @@
+package peers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripANSI(t *testing.T) {
+	require.Equal(t, "red plain title",
+		string(StripANSI([]byte("\x1b[1;31mred\x1b[0m plain\x1b]0;x\x07 title\x1b(B"))))
+	var s ansiStripper
+	require.Equal(t, "abc", string(s.strip([]byte("abc\x1b[3"))))
+	require.Equal(t, "def", string(s.strip([]byte("1mdef\x1b]0;ti"))))
+	require.Equal(t, "", string(s.strip([]byte("tle\x1b"))))
+	require.Equal(t, "ghi", string(s.strip([]byte("\\ghi"))))
+	require.Empty(t, s.carry)
+}
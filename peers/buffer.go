@@ -1,6 +1,7 @@
 package peers
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -11,6 +12,8 @@ type Buffer struct {
 	end     int
 	m       sync.Mutex
 	size    int
+	// total is the number of bytes ever added
+	total int64
 }
 
 // NewBuffer creates and returns a new buffer of a given size
@@ -35,9 +38,58 @@ func (buffer *Buffer) Add(b []byte) {
 			}
 		}
 	}
+	buffer.total += int64(len(b))
 	buffer.m.Unlock()
 }
 
+// Offset returns the offset of the next byte, the number of bytes ever added
+func (buffer *Buffer) Offset() int64 {
+	buffer.m.Lock()
+	defer buffer.m.Unlock()
+	return buffer.total
+}
+
+// GetRange returns the data between two offsets. It fails if the data was
+// already overwritten
+func (buffer *Buffer) GetRange(start int64, end int64) ([]byte, error) {
+	buffer.m.Lock()
+	defer buffer.m.Unlock()
+	if start < 0 || end < start || end > buffer.total {
+		return nil, fmt.Errorf("bad range: %d-%d", start, end)
+	}
+	if start < buffer.total-int64(buffer.size) {
+		return nil, fmt.Errorf("data is no longer in the buffer")
+	}
+	r := make([]byte, 0, end-start)
+	for o := start; o < end; o++ {
+		r = append(r, buffer.data[o%int64(buffer.size)])
+	}
+	return r, nil
+}
+
+// Tail returns the last n bytes in the buffer, or all of the buffer's data if
+// n is zero or larger. Unlike GetSinceMarker, it leaves the markers as they are
+func (buffer *Buffer) Tail(n int) []byte {
+	buffer.m.Lock()
+	defer buffer.m.Unlock()
+	l := buffer.size
+	if buffer.total < int64(buffer.size) {
+		l = int(buffer.total)
+	}
+	if n <= 0 || n > l {
+		n = l
+	}
+	r := make([]byte, n)
+	start := buffer.end - n
+	if start < 0 {
+		start += buffer.size
+	}
+	for i := range r {
+		r[i] = buffer.data[(start+i)%buffer.size]
+	}
+	return r
+}
+
 // Mark adds a new marker in the next buffer position
 func (buffer *Buffer) Mark(id int) {
 	buffer.m.Lock()
@@ -35,3 +35,33 @@ func TestAddDoubleMarkOverflowRestore(t *testing.T) {
 	require.Equal(t, len(ret), 10)
 	require.Equal(t, ret[0], byte(11))
 }
+func TestGetRange(t *testing.T) {
+	buf := NewBuffer(10)
+	buf.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	require.EqualValues(t, 8, buf.Offset())
+	ret, err := buf.GetRange(2, 5)
+	require.NoError(t, err)
+	require.Equal(t, []byte{3, 4, 5}, ret)
+	buf.Add([]byte{9, 10, 11, 12})
+	ret, err = buf.GetRange(6, 12)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7, 8, 9, 10, 11, 12}, ret)
+	_, err = buf.GetRange(1, 5)
+	require.Error(t, err)
+	_, err = buf.GetRange(10, 13)
+	require.Error(t, err)
+}
+func TestTail(t *testing.T) {
+	buf := NewBuffer(10)
+	require.Empty(t, buf.Tail(0))
+	buf.Add([]byte{1, 2, 3, 4, 5, 6})
+	buf.Mark(1)
+	require.Equal(t, []byte{5, 6}, buf.Tail(2))
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, buf.Tail(0))
+	buf.Add([]byte{7, 8, 9, 10, 11, 12})
+	require.Equal(t, []byte{10, 11, 12}, buf.Tail(3))
+	require.Equal(t, []byte{3, 4, 5, 6, 7, 8, 9, 10, 11, 12}, buf.Tail(20))
+	// tail leaves the marker in place
+	buf.Add([]byte{13})
+	require.Equal(t, []byte{7, 8, 9, 10, 11, 12, 13}, buf.GetSinceMarker(1))
+}
@@ -0,0 +1,181 @@
+package peers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tuzig/vt10x"
+)
+
+// glyph attributes, as defined in vt10x
+const (
+	attrReverse = 1 << iota
+	attrUnderline
+	attrBold
+	attrGfx
+	attrItalic
+	attrBlink
+)
+
+// ScreenCell is a single character on the screen with its style
+type ScreenCell struct {
+	Char string `json:"char"`
+	// FG & BG are colors in the form #rrggbb, empty for the default color
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Blink     bool   `json:"blink,omitempty"`
+	Reverse   bool   `json:"reverse,omitempty"`
+}
+
+// ScreenCursor is the cursor's position, zero based
+type ScreenCursor struct {
+	X       int  `json:"x"`
+	Y       int  `json:"y"`
+	Visible bool `json:"visible"`
+}
+
+// Screen is a capture of a pane's screen
+type Screen struct {
+	Rows   int            `json:"rows"`
+	Cols   int            `json:"cols"`
+	Title  string         `json:"title,omitempty"`
+	Cursor ScreenCursor   `json:"cursor"`
+	Lines  [][]ScreenCell `json:"lines"`
+}
+
+// colorString returns a color in the form #rrggbb or "" for the default
+func colorString(c vt10x.Color) string {
+	if c == vt10x.DefaultFG || c == vt10x.DefaultBG || c == vt10x.DefaultCursor {
+		return ""
+	}
+	return fmt.Sprintf("#%06x", uint32(c))
+}
+
+// printAttrs returns the sequence that resets the style & sets the glyph's
+// attributes
+func printAttrs(mode int16) string {
+	ret := "\x1b[0"
+	if mode&attrBold != 0 {
+		ret += ";1"
+	}
+	if mode&attrItalic != 0 {
+		ret += ";3"
+	}
+	if mode&attrUnderline != 0 {
+		ret += ";4"
+	}
+	if mode&attrBlink != 0 {
+		ret += ";5"
+	}
+	if mode&attrReverse != 0 {
+		ret += ";7"
+	}
+	return ret + "m"
+}
+
+// renderScreen returns the screen's content as text, one line per row. When
+// styled is true the text includes the escape sequences that set the colors &
+// attributes. Otherwise, trailing spaces are trimmed.
+// The caller should lock the terminal
+func renderScreen(t vt10x.Terminal, styled bool, eol string) string {
+	var (
+		b              strings.Builder
+		prevFG, prevBG vt10x.Color
+		prevMode       int16
+	)
+	cols, rows := t.Size()
+	for y := 0; y < rows; y++ {
+		var line strings.Builder
+		for x := 0; x < cols; x++ {
+			glyph := t.Cell(x, y)
+			if styled {
+				modeChanged := glyph.Mode != prevMode
+				if modeChanged {
+					line.WriteString(printAttrs(glyph.Mode))
+					prevMode = glyph.Mode
+				}
+				if modeChanged || glyph.FG != prevFG || glyph.BG != prevBG {
+					line.WriteString(printColorChange(glyph.FG, glyph.BG))
+					prevFG = glyph.FG
+					prevBG = glyph.BG
+				}
+			}
+			line.WriteRune(glyph.Char)
+		}
+		if styled {
+			b.WriteString(line.String())
+		} else {
+			b.WriteString(strings.TrimRight(line.String(), " "))
+		}
+		if y < rows-1 {
+			b.WriteString(eol)
+		}
+	}
+	return b.String()
+}
+
+// ScreenText returns the text on the pane's screen. When styled is true the
+// text includes the escape sequences to set colors & attributes
+func (pane *Pane) ScreenText(styled bool) ([]byte, error) {
+	t := pane.vt
+	if t == nil {
+		return nil, fmt.Errorf("pane %d has no screen", pane.ID)
+	}
+	t.Lock()
+	defer t.Unlock()
+	s := renderScreen(t, styled, "\n")
+	if styled {
+		s += "\x1b[0m"
+	}
+	return []byte(s + "\n"), nil
+}
+
+// Screen returns the pane's screen cells & cursor
+func (pane *Pane) Screen() (*Screen, error) {
+	t := pane.vt
+	if t == nil {
+		return nil, fmt.Errorf("pane %d has no screen", pane.ID)
+	}
+	t.Lock()
+	defer t.Unlock()
+	cols, rows := t.Size()
+	c := t.Cursor()
+	screen := &Screen{
+		Rows:   rows,
+		Cols:   cols,
+		Title:  t.Title(),
+		Cursor: ScreenCursor{X: c.X, Y: c.Y, Visible: t.CursorVisible()},
+		Lines:  make([][]ScreenCell, rows),
+	}
+	for y := 0; y < rows; y++ {
+		line := make([]ScreenCell, cols)
+		for x := 0; x < cols; x++ {
+			glyph := t.Cell(x, y)
+			line[x] = ScreenCell{
+				Char:      string(glyph.Char),
+				FG:        colorString(glyph.FG),
+				BG:        colorString(glyph.BG),
+				Bold:      glyph.Mode&attrBold != 0,
+				Italic:    glyph.Mode&attrItalic != 0,
+				Underline: glyph.Mode&attrUnderline != 0,
+				Blink:     glyph.Mode&attrBlink != 0,
+				Reverse:   glyph.Mode&attrReverse != 0,
+			}
+		}
+		screen.Lines[y] = line
+	}
+	return screen, nil
+}
+
+// History returns the last n bytes of the pane's output, or all of the
+// buffer if n is zero. Unless raw is true, escape sequences are removed
+func (pane *Pane) History(n int, raw bool) []byte {
+	b := pane.Buffer.Tail(n)
+	if raw {
+		return b
+	}
+	return StripANSI(b)
+}
@@ -0,0 +1,43 @@
+package peers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCapture(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 3, Cols: 20}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	require.NoError(t, pane.Run([]string{"printf", "\x1b[1;31mhello\x1b[0m world"}))
+	for i := 0; i < 40 && !strings.Contains(string(pane.History(0, false)), "world"); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	text, err := pane.ScreenText(false)
+	require.NoError(t, err)
+	require.Equal(t, "hello world\n\n\n", string(text))
+	styled, err := pane.ScreenText(true)
+	require.NoError(t, err)
+	require.Contains(t, string(styled), "\x1b[0;1m")
+	require.Contains(t, string(styled), "\x1b[38;2;")
+	screen, err := pane.Screen()
+	require.NoError(t, err)
+	require.Equal(t, 3, screen.Rows)
+	require.Equal(t, 20, screen.Cols)
+	require.Equal(t, ScreenCursor{X: 11, Y: 0, Visible: true}, screen.Cursor)
+	require.Equal(t, "h", screen.Lines[0][0].Char)
+	require.True(t, screen.Lines[0][0].Bold)
+	require.NotEmpty(t, screen.Lines[0][0].FG)
+	require.False(t, screen.Lines[0][6].Bold)
+	require.Empty(t, screen.Lines[0][6].FG)
+	require.Equal(t, "hello world", string(pane.History(0, false)))
+	require.Equal(t, "world", string(pane.History(5, false)))
+	require.Equal(t, "\x1b[0m world", string(pane.History(10, true)))
+}
@@ -6,6 +6,8 @@ package peers
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"syscall"
 
 	"github.com/creack/pty"
 )
@@ -44,6 +46,15 @@ type ResizeArgs struct {
 	Sy     uint16 `json:"sy"`
 }
 
+// SignalPaneArgs is a type that holds the argumnets of the signal_pane command
+type SignalPaneArgs struct {
+	PaneID int    `json:"pane_id"`
+	Signal string `json:"signal"`
+	// Group is true when the signal should be sent to the foreground
+	// process group and not just the pane's leader process
+	Group bool `json:"group,omitempty"`
+}
+
 type AddPaneArgs struct {
 	Command []string `json:"command"`
 	Rows    uint16   `json:"rows, omitempty"`
@@ -51,12 +62,215 @@ type AddPaneArgs struct {
 	X       uint16   `json:"x, omitempty"`
 	Y       uint16   `json:"y, omitempty"`
 	Parent  int      `json:"parent,omitempty"`
+	// Cwd is the working directory, relative paths start at the parent's cwd
+	// or the home directory
+	Cwd      string            `json:"cwd,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	EnvUnset []string          `json:"env_unset,omitempty"`
+	// Profile is the name of a profile from the conf file to use for defaults
+	Profile string `json:"profile,omitempty"`
+	// Restart is the restart policy: never, on-failure or always
+	Restart string `json:"restart,omitempty"`
+	// MaxRestarts limits the number of restarts, 0 for no limit
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// RestartDelay is the delay before the first restart, in msec
+	RestartDelay int `json:"restart_delay,omitempty"`
+	// Triggers are matched against the pane's output
+	Triggers []Trigger `json:"triggers,omitempty"`
+	// Sockets are unix sockets the pane's processes can use to reach the
+	// client, i.e. an ssh-agent
+	Sockets []SocketForward `json:"sockets,omitempty"`
+}
+
+// SocketForward is a unix socket the agent creates for a pane and forwards to
+// the client
+type SocketForward struct {
+	// Name identifies the socket to the client, i.e. "ssh-agent"
+	Name string `json:"name" toml:"name"`
+	// Env is the environment variable set to the socket's path, defaults to
+	// SSH_AUTH_SOCK for "ssh-agent"
+	Env string `json:"env,omitempty" toml:"env,omitempty"`
 }
 
 type ReconnectPaneArgs struct {
 	ID int `json:"id"`
 }
 
+// PaneExitedArgs is a type that holds the args of a pane_exited message
+type PaneExitedArgs struct {
+	PaneID   int `json:"pane_id"`
+	ExitCode int `json:"exit_code"`
+	// Signal holds the name of the signal that terminated the process, if any
+	Signal string `json:"signal,omitempty"`
+	// Runtime is in msec
+	Runtime int64 `json:"runtime"`
+}
+
+// PaneRestartedArgs is a type that holds the args of a pane_restarted message
+type PaneRestartedArgs struct {
+	PaneID int `json:"pane_id"`
+	// Restarts is the number of times the pane's command was restarted
+	Restarts int `json:"restarts"`
+}
+
+// GetPaneInfoArgs is a type that holds the argumnets of get_pane_info
+type GetPaneInfoArgs struct {
+	PaneID int `json:"pane_id"`
+}
+
+// PaneProcessInfo holds the state of a pane's processes
+type PaneProcessInfo struct {
+	PaneID int `json:"pane_id"`
+	// Foreground & ForegroundPID are of the leader of the tty's foreground
+	// process group
+	Foreground    string `json:"foreground"`
+	ForegroundPID int    `json:"foreground_pid"`
+	// Cwd is the current directory of the pane's shell
+	Cwd string `json:"cwd"`
+	// CPU is the cpu usage, in percents, & RSS the resident memory, in bytes,
+	// of all the processes in the pane
+	CPU float64 `json:"cpu"`
+	RSS uint64  `json:"rss"`
+}
+
+// PaneTitleArgs is a type that holds the args of a pane_title message
+type PaneTitleArgs struct {
+	PaneID int    `json:"pane_id"`
+	Title  string `json:"title"`
+}
+
+// ForwardPortArgs is a type that holds the args of a forward_port message
+type ForwardPortArgs struct {
+	// Host defaults to localhost
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port"`
+}
+
+// ListenPortArgs is a type that holds the args of a listen_port message
+type ListenPortArgs struct {
+	// Host is the address to listen on, defaults to 127.0.0.1
+	Host string `json:"host,omitempty"`
+	// Port 0 listens on any free port
+	Port int `json:"port"`
+}
+
+// ListenerInfo is the body of a listen_port ack
+type ListenerInfo struct {
+	ID      int    `json:"id"`
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+}
+
+// UnlistenPortArgs is a type that holds the args of an unlisten_port message
+type UnlistenPortArgs struct {
+	ID int `json:"id"`
+}
+
+// PaneRenamedArgs is a type that holds the args of a pane_renamed message
+type PaneRenamedArgs struct {
+	PaneID int    `json:"pane_id"`
+	Name   string `json:"name"`
+}
+
+// PaneKilledArgs is a type that holds the args of a pane_killed message
+type PaneKilledArgs struct {
+	PaneID int `json:"pane_id"`
+}
+
+// PortArgs is a type that holds the args of port_opened & port_closed
+// messages
+type PortArgs struct {
+	PaneID int `json:"pane_id"`
+	Port   int `json:"port"`
+	// Address is the address the process listens on, i.e. 127.0.0.1 or ::
+	Address string `json:"address"`
+	PID     int    `json:"pid"`
+	Process string `json:"process"`
+}
+
+// PaneCwdArgs is a type that holds the args of a pane_cwd message
+type PaneCwdArgs struct {
+	PaneID int    `json:"pane_id"`
+	Cwd    string `json:"cwd"`
+}
+
+// GetCommandHistoryArgs is a type that holds the argumnets of
+// get_command_history
+type GetCommandHistoryArgs struct {
+	PaneID int `json:"pane_id"`
+	// Limit is the maximum number of commands to return, 0 for all
+	Limit int `json:"limit,omitempty"`
+}
+
+// GetCommandOutputArgs is a type that holds the argumnets of
+// get_command_output
+type GetCommandOutputArgs struct {
+	PaneID int `json:"pane_id"`
+	// ID is the command's id, 0 for the last command
+	ID int `json:"id,omitempty"`
+	// Plain is true when the escape sequences should be removed
+	Plain bool `json:"plain,omitempty"`
+}
+
+// NotifyPaneArgs is a type that holds the argumnets of notify_pane
+type NotifyPaneArgs struct {
+	PaneID int `json:"pane_id"`
+	// Threshold is the minimal runtime of the commands to notify about, in
+	// msec
+	Threshold int `json:"threshold,omitempty"`
+	// Off is true to stop the notifications
+	Off bool `json:"off,omitempty"`
+}
+
+// NotifyArgs is a type that holds the args of a notify message
+type NotifyArgs struct {
+	PaneID int    `json:"pane_id"`
+	Title  string `json:"title,omitempty"`
+	// Reason is one of completed, osc or bell
+	Reason   string `json:"reason"`
+	Command  string `json:"command,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	// Duration is the command's runtime in msec
+	Duration int64  `json:"duration,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+// WatchPaneArgs is a type that holds the argumnets of watch_pane
+type WatchPaneArgs struct {
+	PaneID   int       `json:"pane_id"`
+	Triggers []Trigger `json:"triggers,omitempty"`
+	// Clear is true to remove the pane's triggers before adding new ones
+	Clear bool `json:"clear,omitempty"`
+}
+
+// PaneMarkArgs is a type that holds the args of a pane_mark message
+type PaneMarkArgs struct {
+	PaneID int `json:"pane_id"`
+	// Marker can be used to restore the pane's output since the match
+	Marker    int    `json:"marker"`
+	TriggerID int    `json:"trigger_id"`
+	Text      string `json:"text"`
+}
+
+// PaneInfo holds the details of a pane, as listed by list_panes
+type PaneInfo struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name,omitempty"`
+	Command []string `json:"command"`
+	Rows    uint16   `json:"rows"`
+	Cols    uint16   `json:"cols"`
+	Running bool     `json:"running"`
+	Title   string   `json:"title,omitempty"`
+	// Created & LastOutput are in msec since the epoch
+	Created    int64 `json:"created"`
+	Parent     int   `json:"parent,omitempty"`
+	Clients    int   `json:"clients"`
+	LastOutput int64 `json:"last_output,omitempty"`
+	// Peers has the fingerprints of the peers attached to the pane, and
+	// "local" for clients attached over the unix socket
+	Peers []string `json:"peers,omitempty"`
+}
+
 type SetClipboardArgs struct {
 	Data     string `json:"data"`
 	MimeType string `json:"mimetype"`
@@ -79,3 +293,41 @@ func ParseWinsize(s string) (*pty.Winsize, error) {
 	}
 	return &pty.Winsize{Rows: uint16(sy), Cols: uint16(sx)}, nil
 }
+
+// signals holds the signals a client can send to a pane
+var signals = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"TSTP": syscall.SIGTSTP,
+	"CONT": syscall.SIGCONT,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// ParseSignal gets a signal name such as "INT" or "SIGINT" and returns the
+// signal
+func ParseSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("Unsupported signal: %q", s)
+	}
+	return sig, nil
+}
+
+// killSignals holds the signals that can be used to terminate a pane
+var killSignals = []string{"INT", "TERM", "HUP", "QUIT", "USR1", "USR2"}
+
+// ParseKillSignal is like ParseSignal but accepts only the signals that can
+// be used to terminate a pane
+func ParseKillSignal(s string) (syscall.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	for _, n := range killSignals {
+		if n == name {
+			return signals[name], nil
+		}
+	}
+	return 0, fmt.Errorf("Unsupported kill signal: %q", s)
+}
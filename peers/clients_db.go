@@ -8,9 +8,18 @@ import (
 	"github.com/pion/webrtc/v3"
 )
 
-// Client ties together the dta channel, its peer and the pane
+// ClientChannel is the channel a client gets its pane's output on. It's
+// implemented by webrtc data channels and by clients on the unix socket
+type ClientChannel interface {
+	ReadyState() webrtc.DataChannelState
+	Send([]byte) error
+	Close() error
+}
+
+// Client ties together the dta channel, its peer and the pane. Local clients
+// have no peer
 type Client struct {
-	dc   *webrtc.DataChannel
+	dc   ClientChannel
 	pane *Pane
 	peer *Peer
 	id   int
@@ -29,7 +38,7 @@ func NewClientsDB() *ClientsDB {
 }
 
 // Add adds a Client to the db
-func (db *ClientsDB) Add(dc *webrtc.DataChannel, pane *Pane, peer *Peer) *Client {
+func (db *ClientsDB) Add(dc ClientChannel, pane *Pane, peer *Peer) *Client {
 	db.m.Lock()
 	defer db.m.Unlock()
 	id := db.lastID
@@ -78,7 +87,7 @@ func (db *ClientsDB) Delete(c *Client) error {
 	defer db.m.Unlock()
 
 	for k, v := range db.clients {
-		if v.dc.ID() == c.dc.ID() && v.pane.ID == c.pane.ID {
+		if v.dc == c.dc && v.pane.ID == c.pane.ID {
 			delete(db.clients, k)
 			return nil
 		}
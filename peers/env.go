@@ -0,0 +1,110 @@
+// This file holds the code that builds the environment of new panes
+package peers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riywo/loginshell"
+)
+
+// Environment policies, used to set the base environment of new panes
+const (
+	// EnvPolicyClean starts panes with only the configured env vars
+	EnvPolicyClean = "clean"
+	// EnvPolicyInherit passes the agent's environment to the panes
+	EnvPolicyInherit = "inherit"
+	// EnvPolicyLogin uses the environment of the user's login shell
+	EnvPolicyLogin = "login"
+)
+
+// loginShellTimeout is how long to wait for the login shell to print its env
+const loginShellTimeout = 5 * time.Second
+
+var (
+	loginEnv    map[string]string
+	loginEnvErr error
+	loginEnvM   sync.Mutex
+)
+
+// ignoredEnv holds variables that belong to the process that set them
+var ignoredEnv = []string{"PWD", "OLDPWD", "SHLVL", "_"}
+
+// ValidEnvPolicy returns true if the policy is one of the known policies
+func ValidEnvPolicy(policy string) bool {
+	return policy == EnvPolicyClean || policy == EnvPolicyInherit ||
+		policy == EnvPolicyLogin
+}
+
+// baseEnv returns the environment panes start with, based on the policy
+func (conf *Conf) baseEnv() map[string]string {
+	switch conf.EnvPolicy {
+	case EnvPolicyInherit:
+		return parseEnv(os.Environ())
+	case EnvPolicyLogin:
+		env, err := getLoginEnv()
+		if err != nil {
+			conf.Logger.Warnf(
+				"Failed to get the login shell's env, falling back to the agent's: %s", err)
+			return parseEnv(os.Environ())
+		}
+		return env
+	}
+	return map[string]string{}
+}
+
+// getLoginEnv returns the environment of the user's login shell. The shell
+// is run only once and its environment, or the failure to get it, is cached.
+func getLoginEnv() (map[string]string, error) {
+	loginEnvM.Lock()
+	defer loginEnvM.Unlock()
+	if loginEnv == nil && loginEnvErr == nil {
+		loginEnv, loginEnvErr = runLoginShell()
+	}
+	if loginEnvErr != nil {
+		return nil, loginEnvErr
+	}
+	// return a copy so the cache can't be changed by the callers
+	ret := make(map[string]string, len(loginEnv))
+	for k, v := range loginEnv {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+// runLoginShell runs the user's login shell and returns its environment
+func runLoginShell() (map[string]string, error) {
+	shell, err := loginshell.Shell()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to determine user's shell: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), loginShellTimeout)
+	defer cancel()
+	// env -0 separates the vars with NUL so values can hold new lines
+	out, err := exec.CommandContext(ctx, shell, "-l", "-c", "env -0").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Failed running %s: %s", shell, err)
+	}
+	return parseEnv(strings.Split(string(out), "\x00")), nil
+}
+
+// parseEnv gets a slice of "key=value" strings and returns a map
+func parseEnv(vars []string) map[string]string {
+	env := make(map[string]string)
+	for _, v := range vars {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		env[kv[0]] = kv[1]
+	}
+	for _, k := range ignoredEnv {
+		delete(env, k)
+	}
+	return env
+}
@@ -0,0 +1,25 @@
+package peers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnv(t *testing.T) {
+	env := parseEnv([]string{"HOME=/home/rose", "MOTD=hello\nworld=1",
+		"PWD=/tmp", "EMPTY=", ""})
+	require.Equal(t, map[string]string{
+		"HOME":  "/home/rose",
+		"MOTD":  "hello\nworld=1",
+		"EMPTY": "",
+	}, env)
+}
+
+func TestBaseEnv(t *testing.T) {
+	t.Setenv("BADWOLF", "Rose")
+	conf := Conf{EnvPolicy: EnvPolicyClean}
+	require.Empty(t, conf.baseEnv())
+	conf.EnvPolicy = EnvPolicyInherit
+	require.Equal(t, "Rose", conf.baseEnv()["BADWOLF"])
+}
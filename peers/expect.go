@@ -0,0 +1,160 @@
+// This file holds the code that runs expect style scripts against panes
+package peers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultExpectTimeout is the timeout of expect steps that don't set one
+const DefaultExpectTimeout = 10 * time.Second
+
+// expectWindow is the length of the unmatched output kept for matching, older
+// output is dropped
+const expectWindow = 2 * OutBufSize
+
+// maxTranscript is the length of the output kept for an expect result
+const maxTranscript = 64 * 1024
+
+// ExpectStep is a step in an expect script. A step can send text to the pane,
+// wait for the output to match a regular expression or both, sending first
+type ExpectStep struct {
+	Send   string `json:"send,omitempty"`
+	Expect string `json:"expect,omitempty"`
+	// Timeout is in msec
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// ExpectResult is the result of running an expect script
+type ExpectResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Step is the number of the failed step, starting at 1
+	Step int `json:"step,omitempty"`
+	// Transcript is the pane's output during the run, without escape
+	// sequences. Only the end of the output is kept when it's longer than
+	// maxTranscript and then Truncated is set
+	Transcript string `json:"transcript"`
+	Truncated  bool   `json:"truncated,omitempty"`
+	// Captures has an array for each expect step, with the matched text
+	// followed by the groups
+	Captures [][]string `json:"captures"`
+}
+
+// CompileExpect validates the steps and returns their regular expressions
+func CompileExpect(steps []ExpectStep) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(steps))
+	for i, step := range steps {
+		if step.Timeout < 0 {
+			return nil, fmt.Errorf("step %d has a negative timeout", i)
+		}
+		if step.Expect == "" {
+			if step.Send == "" {
+				return nil, fmt.Errorf("step %d has nothing to send or expect", i)
+			}
+			continue
+		}
+		re, err := regexp.Compile(step.Expect)
+		if err != nil {
+			return nil, fmt.Errorf("step %d has a bad pattern: %s", i, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// Expect runs the steps against the pane. Only output that comes after the
+// call is matched and each match starts after the previous one. The run stops
+// when ctx is done.
+func (pane *Pane) Expect(ctx context.Context, steps []ExpectStep, timeout time.Duration) (*ExpectResult, error) {
+	res, err := CompileExpect(steps)
+	if err != nil {
+		return nil, err
+	}
+	if timeout == 0 {
+		timeout = DefaultExpectTimeout
+	}
+	out, done := pane.Subscribe()
+	defer done()
+	var (
+		stripper   ansiStripper
+		pending    string
+		transcript string
+	)
+	result := &ExpectResult{Captures: [][]string{}}
+	fail := func(step int, err error) (*ExpectResult, error) {
+		result.Step = step
+		result.Error = fmt.Sprintf("step %d: %s", step, err)
+		result.Transcript = transcript
+		return result, nil
+	}
+	for i, step := range steps {
+		if step.Send != "" {
+			err := pane.SendInput([]byte(step.Send))
+			if err != nil {
+				return fail(i+1, fmt.Errorf("failed to send: %s", err))
+			}
+		}
+		re := res[i]
+		if re == nil {
+			continue
+		}
+		t := timeout
+		if step.Timeout > 0 {
+			t = time.Duration(step.Timeout) * time.Millisecond
+		}
+		timer := time.NewTimer(t)
+	match:
+		for {
+			if loc := re.FindStringSubmatchIndex(pending); loc != nil {
+				captures := make([]string, len(loc)/2)
+				for j := range captures {
+					if loc[2*j] >= 0 {
+						captures[j] = pending[loc[2*j]:loc[2*j+1]]
+					}
+				}
+				result.Captures = append(result.Captures, captures)
+				pending = pending[loc[1]:]
+				timer.Stop()
+				break match
+			}
+			select {
+			case b, ok := <-out:
+				if !ok {
+					timer.Stop()
+					return fail(i+1, fmt.Errorf("pane %d was closed", pane.ID))
+				}
+				text := string(stripper.strip(b))
+				transcript += text
+				if len(transcript) > maxTranscript {
+					transcript = trimStart(transcript, len(transcript)-maxTranscript)
+					result.Truncated = true
+				}
+				pending += text
+				if len(pending) > expectWindow {
+					pending = trimStart(pending, len(pending)-expectWindow)
+				}
+			case <-ctx.Done():
+				timer.Stop()
+				return fail(i+1, ctx.Err())
+			case <-timer.C:
+				return fail(i+1, fmt.Errorf("timeout waiting for %q", step.Expect))
+			}
+		}
+	}
+	result.OK = true
+	result.Transcript = transcript
+	return result, nil
+}
+
+// trimStart removes at least n bytes from the start of s, without splitting
+// a UTF-8 character
+func trimStart(s string, n int) string {
+	for n < len(s) && !utf8.RuneStart(s[n]) {
+		n++
+	}
+	return s[n:]
+}
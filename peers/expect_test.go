@@ -0,0 +1,40 @@
+package peers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestExpectTranscript(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	require.NoError(t, pane.Run([]string{"sh"}))
+	defer pane.Kill()
+	result, err := pane.Expect(context.Background(), []ExpectStep{
+		{Send: "head -c 100000 /dev/zero | tr '\\0' a\n", Expect: "never", Timeout: 1000},
+	}, 0)
+	require.NoError(t, err)
+	require.False(t, result.OK)
+	require.True(t, result.Truncated)
+	require.Len(t, result.Transcript, maxTranscript)
+	// a canceled context stops the script
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	result, err = pane.Expect(ctx, []ExpectStep{{Expect: "never"}}, 0)
+	require.NoError(t, err)
+	require.Contains(t, result.Error, "canceled")
+}
+
+func TestTrimStart(t *testing.T) {
+	require.Equal(t, "bc", trimStart("abc", 1))
+	// the multi byte character is dropped, not split
+	require.Equal(t, "x", trimStart("שx", 1))
+}
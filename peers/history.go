@@ -0,0 +1,161 @@
+// This file holds the code that keeps the panes' command history, based on
+// the OSC 133 marks sent by shells with shell integration
+package peers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHistory is the number of commands kept for each pane
+const maxHistory = 100
+
+// Command is a command that ran in a pane's shell
+type Command struct {
+	ID      int    `json:"id"`
+	Command string `json:"command"`
+	// Start & End are the offsets of the command's output in the pane's
+	// buffer. End is 0 while the command is running
+	Start    int64 `json:"start"`
+	End      int64 `json:"end,omitempty"`
+	ExitCode *int  `json:"exit_code,omitempty"`
+	// Started is in msec since the epoch & Duration in msec
+	Started  int64 `json:"started"`
+	Duration int64 `json:"duration,omitempty"`
+}
+
+// shellState holds the state of the pane's shell, as reported by the marks
+type shellState struct {
+	// inputStart is the offset in the buffer where the command line starts
+	inputStart int64
+	current    *Command
+	history    []*Command
+	lastID     int
+	// marks is true once the shell sent a mark
+	marks bool
+}
+
+// handleShellMark handles an OSC 133 mark. start & end are the offsets of
+// the sequence in the buffer
+func (pane *Pane) handleShellMark(mark string, start int64, end int64) {
+	var done *Command
+	kind, arg, _ := strings.Cut(mark, ";")
+	pane.Lock()
+	shell := &pane.shell
+	shell.marks = true
+	switch kind {
+	case "A":
+		// a new prompt, a command that didn't send D is done
+		if shell.current != nil {
+			done = pane.endCommand(start, nil)
+		}
+	case "B":
+		shell.inputStart = end
+	case "C":
+		line := ""
+		if shell.inputStart > 0 {
+			b, err := pane.Buffer.GetRange(shell.inputStart, start)
+			if err == nil {
+				line = commandLine(b)
+			}
+			shell.inputStart = 0
+		}
+		shell.lastID++
+		shell.current = &Command{
+			ID:      shell.lastID,
+			Command: line,
+			Start:   end,
+			Started: time.Now().UnixMilli(),
+		}
+		shell.history = append(shell.history, shell.current)
+		if len(shell.history) > maxHistory {
+			shell.history = shell.history[1:]
+		}
+	case "D":
+		if shell.current == nil {
+			break
+		}
+		var exitCode *int
+		// the exit code is the first param, other params are key=value
+		code, _, _ := strings.Cut(arg, ";")
+		c, err := strconv.Atoi(code)
+		if err == nil {
+			exitCode = &c
+		}
+		done = pane.endCommand(start, exitCode)
+	}
+	pane.Unlock()
+	if done != nil {
+		pane.commandDone(*done)
+	}
+}
+
+// endCommand marks the current command as done and returns a copy of it.
+// It's called with the pane locked
+func (pane *Pane) endCommand(end int64, exitCode *int) *Command {
+	c := pane.shell.current
+	c.End = end
+	c.ExitCode = exitCode
+	c.Duration = time.Now().UnixMilli() - c.Started
+	pane.shell.current = nil
+	done := *c
+	return &done
+}
+
+// CommandHistory returns up to limit last commands, oldest first. A limit of
+// 0 returns all the commands
+func (pane *Pane) CommandHistory(limit int) []Command {
+	pane.Lock()
+	defer pane.Unlock()
+	h := pane.shell.history
+	if limit > 0 && len(h) > limit {
+		h = h[len(h)-limit:]
+	}
+	ret := make([]Command, 0, len(h))
+	for _, c := range h {
+		ret = append(ret, *c)
+	}
+	return ret
+}
+
+// CommandOutput returns the output of a command. An id of 0 gets the last
+// command. The output of a running command is the output so far
+func (pane *Pane) CommandOutput(id int) ([]byte, error) {
+	pane.Lock()
+	var c *Command
+	for i := len(pane.shell.history) - 1; i >= 0 && c == nil; i-- {
+		if id == 0 || pane.shell.history[i].ID == id {
+			c = pane.shell.history[i]
+		}
+	}
+	if c == nil {
+		pane.Unlock()
+		return nil, fmt.Errorf("command %d not found", id)
+	}
+	start, end := c.Start, c.End
+	pane.Unlock()
+	if end == 0 {
+		end = pane.Buffer.Offset()
+	}
+	return pane.Buffer.GetRange(start, end)
+}
+
+// commandLine returns the text of the command line typed between the B & C
+// marks, without escape sequences, control characters & the final newline
+func commandLine(b []byte) string {
+	var line []rune
+	for _, r := range string(StripANSI(b)) {
+		switch {
+		case r == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case r < ' ' || r == 0x7f:
+		default:
+			line = append(line, r)
+		}
+	}
+	return strings.TrimSpace(string(line))
+}
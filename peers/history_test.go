@@ -0,0 +1,42 @@
+package peers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCommandHistory(t *testing.T) {
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, nil, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	feed := func(s string) {
+		pane.Buffer.Add([]byte(s))
+		pane.handleOutput([]byte(s))
+	}
+	feed("\x1b]133;A\x07$ \x1b]133;B\x07ls -x\b\x1b[1ml\r\n\x1b]133;C\x07")
+	feed("a.txt\r\nb.txt\r\n\x1b]133")
+	feed(";D;0\x07\x1b]133;A\x07$ \x1b]133;B\x07make\r\n\x1b]133;C\x07\x1b[31mfailed")
+	h := pane.CommandHistory(0)
+	require.Len(t, h, 2)
+	require.Equal(t, "ls -l", h[0].Command)
+	require.Equal(t, 0, *h[0].ExitCode)
+	require.Equal(t, "make", h[1].Command)
+	require.Nil(t, h[1].ExitCode)
+	require.Zero(t, h[1].End)
+	out, err := pane.CommandOutput(h[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "a.txt\r\nb.txt\r\n", string(out))
+	// the last command is still running
+	out, err = pane.CommandOutput(0)
+	require.NoError(t, err)
+	require.Equal(t, "failed", string(StripANSI(out)))
+	feed("\x1b]133;D;2\x07")
+	h = pane.CommandHistory(1)
+	require.Len(t, h, 1)
+	require.Equal(t, 2, *h[0].ExitCode)
+	_, err = pane.CommandOutput(42)
+	require.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package peers
+
+import (
+	"strings"
+
+	"github.com/tuzig/vt10x"
+)
+
+// keyNames maps key names, as used by `tmux send-keys`, to their input
+var keyNames = map[string]string{
+	"enter":    "\r",
+	"tab":      "\t",
+	"btab":     "\x1b[Z",
+	"space":    " ",
+	"bspace":   "\x7f",
+	"escape":   "\x1b",
+	"home":     "\x1b[1~",
+	"end":      "\x1b[4~",
+	"ic":       "\x1b[2~",
+	"insert":   "\x1b[2~",
+	"dc":       "\x1b[3~",
+	"delete":   "\x1b[3~",
+	"ppage":    "\x1b[5~",
+	"pageup":   "\x1b[5~",
+	"pgup":     "\x1b[5~",
+	"npage":    "\x1b[6~",
+	"pagedown": "\x1b[6~",
+	"pgdn":     "\x1b[6~",
+	"f1":       "\x1bOP",
+	"f2":       "\x1bOQ",
+	"f3":       "\x1bOR",
+	"f4":       "\x1bOS",
+	"f5":       "\x1b[15~",
+	"f6":       "\x1b[17~",
+	"f7":       "\x1b[18~",
+	"f8":       "\x1b[19~",
+	"f9":       "\x1b[20~",
+	"f10":      "\x1b[21~",
+	"f11":      "\x1b[23~",
+	"f12":      "\x1b[24~",
+}
+
+// cursorKeys holds the final byte of the cursor keys' sequences
+var cursorKeys = map[string]byte{
+	"up":    'A',
+	"down":  'B',
+	"right": 'C',
+	"left":  'D',
+}
+
+// keyInput returns the input of a single key name, i.e. `Enter`, `C-c` or
+// `M-x`. appCursor is true when the terminal is in application cursor mode.
+// ok is false if key isn't a known key name
+func keyInput(key string, appCursor bool) (string, bool) {
+	if len(key) == 3 && (key[:2] == "M-" || key[:2] == "m-") {
+		return "\x1b" + key[2:], true
+	}
+	if len(key) > 3 && (key[:2] == "M-" || key[:2] == "m-") {
+		s, ok := keyInput(key[2:], appCursor)
+		if !ok {
+			return "", false
+		}
+		return "\x1b" + s, true
+	}
+	if len(key) == 3 && (key[:2] == "C-" || key[:2] == "c-") {
+		return ctrlKey(key[2])
+	}
+	if len(key) == 2 && key[0] == '^' {
+		return ctrlKey(key[1])
+	}
+	name := strings.ToLower(key)
+	if c, ok := cursorKeys[name]; ok {
+		if appCursor {
+			return "\x1bO" + string(c), true
+		}
+		return "\x1b[" + string(c), true
+	}
+	s, ok := keyNames[name]
+	return s, ok
+}
+
+// ctrlKey returns the input of pressing control and c
+func ctrlKey(c byte) (string, bool) {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return string(c - 'a' + 1), true
+	case c >= '@' && c <= '_':
+		return string(c - '@'), true
+	case c == ' ':
+		return "\x00", true
+	case c == '?':
+		return "\x7f", true
+	}
+	return "", false
+}
+
+// KeysInput translates a list of keys to the input they generate. Each key is
+// either a key name or text that is sent as is. When literal is true, key
+// names are not translated.
+func KeysInput(keys []string, literal bool, appCursor bool) []byte {
+	var b strings.Builder
+	for _, key := range keys {
+		if !literal {
+			if s, ok := keyInput(key, appCursor); ok {
+				b.WriteString(s)
+				continue
+			}
+		}
+		b.WriteString(key)
+	}
+	return []byte(b.String())
+}
+
+// SendKeys sends keys to the pane, as if they were typed by a client
+func (pane *Pane) SendKeys(keys []string, literal bool) error {
+	appCursor := false
+	if pane.vt != nil {
+		pane.vt.Lock()
+		appCursor = pane.vt.Mode()&vt10x.ModeAppCursor != 0
+		pane.vt.Unlock()
+	}
+	return pane.SendInput(KeysInput(keys, literal, appCursor))
+}
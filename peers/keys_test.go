@@ -0,0 +1,32 @@
+package peers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeysInput(t *testing.T) {
+	cases := []struct {
+		keys      []string
+		literal   bool
+		appCursor bool
+		want      string
+	}{
+		{[]string{"make test", "Enter"}, false, false, "make test\r"},
+		{[]string{"C-c"}, false, false, "\x03"},
+		{[]string{"^D", "c-a"}, false, false, "\x04\x01"},
+		{[]string{"M-x"}, false, false, "\x1bx"},
+		{[]string{"M-Enter"}, false, false, "\x1b\r"},
+		{[]string{"Escape", "Tab", "BSpace"}, false, false, "\x1b\t\x7f"},
+		{[]string{"Up", "left"}, false, false, "\x1b[A\x1b[D"},
+		{[]string{"Up"}, false, true, "\x1bOA"},
+		{[]string{"F5", "PageDown"}, false, false, "\x1b[15~\x1b[6~"},
+		{[]string{"Enter", "C-c"}, true, false, "EnterC-c"},
+		{[]string{"C-cc", "Enterprise"}, false, false, "C-ccEnterprise"},
+	}
+	for _, c := range cases {
+		got := KeysInput(c.keys, c.literal, c.appCursor)
+		require.Equal(t, c.want, string(got), "keys: %q", c.keys)
+	}
+}
@@ -0,0 +1,199 @@
+// This file holds the code that notifies the peers when a long command
+// finishes or when a pane asks for the user's attention
+package peers
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification reasons
+const (
+	NotifyCompleted = "completed"
+	NotifyOSC       = "osc"
+	NotifyBell      = "bell"
+	NotifyTrigger   = "trigger"
+)
+
+const (
+	// DefaultNotifyThreshold is the default minimal runtime of commands that
+	// notify on completion
+	DefaultNotifyThreshold = 10 * time.Second
+	// foregroundPollInterval is used to detect commands in shells that don't
+	// send OSC 133 marks
+	foregroundPollInterval = time.Second
+	// minBellInterval limits the rate of the bell notifications
+	minBellInterval = 5 * time.Second
+)
+
+// notifyWatch holds the settings of a pane's notifications
+type notifyWatch struct {
+	threshold time.Duration
+	lastBell  time.Time
+	cancel    context.CancelFunc
+}
+
+// WatchCompletion makes the pane notify the peers when a command that ran
+// longer than threshold finishes, when the pane rings the bell and when it
+// sends a notification using OSC 9 or 777
+func (pane *Pane) WatchCompletion(threshold time.Duration) {
+	if threshold == 0 {
+		threshold = DefaultNotifyThreshold
+	}
+	ctx, cancel := context.WithCancel(pane.ctx)
+	pane.Lock()
+	if pane.notify != nil {
+		pane.notify.cancel()
+	}
+	pane.notify = &notifyWatch{threshold: threshold, cancel: cancel}
+	pane.Unlock()
+	go pane.pollForeground(ctx, threshold)
+}
+
+// UnwatchCompletion stops the pane's notifications
+func (pane *Pane) UnwatchCompletion() {
+	pane.Lock()
+	defer pane.Unlock()
+	if pane.notify != nil {
+		pane.notify.cancel()
+		pane.notify = nil
+	}
+}
+
+// pollForeground is used for shells that don't send OSC 133 marks. It polls
+// the foreground process group and notifies when the shell gets back the
+// terminal after a command ran longer than threshold
+func (pane *Pane) pollForeground(ctx context.Context, threshold time.Duration) {
+	var since time.Time
+	ticker := time.NewTicker(foregroundPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pane.Lock()
+		marks := pane.shell.marks
+		pid := 0
+		if pane.IsRunning && pane.C != nil && pane.C.Process != nil {
+			pid = pane.C.Process.Pid
+		}
+		pane.Unlock()
+		if marks || pid == 0 {
+			continue
+		}
+		pgrp, err := pane.foregroundPgrp()
+		if err != nil {
+			continue
+		}
+		if pgrp != pid {
+			if since.IsZero() {
+				since = time.Now()
+			}
+			continue
+		}
+		if !since.IsZero() && time.Since(since) >= threshold {
+			pane.sendNotify(NotifyArgs{
+				Reason:   NotifyCompleted,
+				Duration: time.Since(since).Milliseconds(),
+			})
+		}
+		since = time.Time{}
+	}
+}
+
+// commandDone is called when the shell marks the end of a command
+func (pane *Pane) commandDone(c Command) {
+	pane.Lock()
+	watch := pane.notify
+	pane.Unlock()
+	if watch == nil || time.Duration(c.Duration)*time.Millisecond < watch.threshold {
+		return
+	}
+	pane.sendNotify(NotifyArgs{
+		Reason:   NotifyCompleted,
+		Command:  c.Command,
+		ExitCode: c.ExitCode,
+		Duration: c.Duration,
+	})
+}
+
+// handleNotifySeq handles OSC 9 & OSC 777 notifications
+func (pane *Pane) handleNotifySeq(cmd string, arg string) {
+	var args NotifyArgs
+	switch cmd {
+	case "9":
+		// ConEmu uses "9;<number>;..." for other things, like progress
+		n, _, _ := strings.Cut(arg, ";")
+		if _, err := strconv.Atoi(n); err == nil {
+			return
+		}
+		args.Message = arg
+	case "777":
+		// 777;notify;title;body
+		fields := strings.SplitN(arg, ";", 3)
+		if len(fields) != 3 || fields[0] != "notify" {
+			return
+		}
+		args.Message = fields[1] + ": " + fields[2]
+	}
+	pane.Lock()
+	watch := pane.notify
+	pane.Unlock()
+	if watch != nil {
+		args.Reason = NotifyOSC
+		pane.sendNotify(args)
+	}
+}
+
+// handleBell notifies the peers when the pane rings the bell
+func (pane *Pane) handleBell() {
+	pane.Lock()
+	watch := pane.notify
+	if watch == nil || time.Since(watch.lastBell) < minBellInterval {
+		pane.Unlock()
+		return
+	}
+	watch.lastBell = time.Now()
+	pane.Unlock()
+	pane.sendNotify(NotifyArgs{Reason: NotifyBell})
+}
+
+// sendNotify sends a notify message to the connected peers
+func (pane *Pane) sendNotify(args NotifyArgs) {
+	args.PaneID = pane.ID
+	pane.Lock()
+	args.Title = pane.title
+	pane.Unlock()
+	pane.conf.Logger.Infof("@%d: notifying peers: %s", pane.ID, args.Reason)
+	BroadcastConnected("notify", &args)
+}
+
+// hasBell returns true if b has a BEL that's not part of an OSC sequence.
+// seqs are the sequences found in b and partial is the length of the
+// incomplete sequence at its end
+func hasBell(b []byte, seqs []oscSeq, partial int) bool {
+	end := len(b) - partial
+	if end < 0 {
+		end = 0
+	}
+	for i, c := range b[:end] {
+		if c != '\x07' {
+			continue
+		}
+		inSeq := false
+		for _, s := range seqs {
+			if i >= s.start && i < s.end {
+				inSeq = true
+				break
+			}
+		}
+		if !inSeq {
+			return true
+		}
+	}
+	return false
+}
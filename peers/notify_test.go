@@ -0,0 +1,79 @@
+package peers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// sentNotifications returns the notify messages sent to a connected peer
+func sentNotifications(t *testing.T, peer *Peer) []NotifyArgs {
+	var ret []NotifyArgs
+	for _, msgJ := range peer.cdc.(*fakeChannel).messages() {
+		var args NotifyArgs
+		m := CTRLMessage{Args: &args}
+		require.NoError(t, json.Unmarshal(msgJ, &m))
+		if m.Type == "notify" {
+			ret = append(ret, args)
+		}
+	}
+	return ret
+}
+
+func TestNotify(t *testing.T) {
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	peer := &Peer{FP: "notify-test", logger: conf.Logger, cdc: &fakeChannel{}}
+	peersM.Lock()
+	if Peers == nil {
+		Peers = make(map[string]*Peer)
+	}
+	Peers[peer.FP] = peer
+	peersM.Unlock()
+	defer func() {
+		peersM.Lock()
+		delete(Peers, peer.FP)
+		peersM.Unlock()
+	}()
+	pane, err := NewPane(conf, nil, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	feed := func(s string) {
+		pane.Buffer.Add([]byte(s))
+		pane.handleOutput([]byte(s))
+	}
+	// no notifications before the pane is watched
+	feed("\a\x1b]9;done\x07")
+	require.Empty(t, sentNotifications(t, peer))
+	pane.WatchCompletion(50 * time.Millisecond)
+	defer pane.UnwatchCompletion()
+	feed("\x1b]0;build\x07\x1b]133;B\x07make\r\n\x1b]133;C\x07")
+	feed("\x1b]133;D;0\x07")
+	require.Empty(t, sentNotifications(t, peer))
+	feed("\x1b]133;B\x07make\r\n\x1b]133;C\x07")
+	time.Sleep(60 * time.Millisecond)
+	feed("\x1b]133;D;2\x07")
+	feed("\x1b]777;notify;Build;finished\x07\a\a")
+	n := sentNotifications(t, peer)
+	require.Len(t, n, 3)
+	require.Equal(t, NotifyCompleted, n[0].Reason)
+	require.Equal(t, "make", n[0].Command)
+	require.Equal(t, 2, *n[0].ExitCode)
+	require.GreaterOrEqual(t, n[0].Duration, int64(50))
+	require.Equal(t, pane.ID, n[0].PaneID)
+	require.Equal(t, NotifyOSC, n[1].Reason)
+	require.Equal(t, "Build: finished", n[1].Message)
+	require.Equal(t, NotifyBell, n[2].Reason)
+}
+
+func TestHasBell(t *testing.T) {
+	var p oscParser
+	b := []byte("\x1b]0;title\x07")
+	require.False(t, hasBell(b, p.parse(b), len(p.partial)))
+	b = []byte("ding\a\x1b]0;ti")
+	require.True(t, hasBell(b, p.parse(b), len(p.partial)))
+	b = []byte("tle\x07")
+	require.False(t, hasBell(b, p.parse(b), len(p.partial)))
+}
@@ -0,0 +1,178 @@
+// This file holds the code that finds & handles OSC sequences in the panes'
+// output
+package peers
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// maxOSCLen is the longest incomplete sequence kept between reads
+const maxOSCLen = 4096
+
+// cwdCheckInterval is the minimal time between reading the foreground
+// process's cwd, used when the shell doesn't send OSC 7
+const cwdCheckInterval = time.Second
+
+// oscParser finds OSC sequences in a pane's output. A sequence can be split
+// between reads so an incomplete sequence is kept until the next read.
+type oscParser struct {
+	partial []byte
+}
+
+// oscSeq is an OSC sequence found in the output. start & end are the
+// sequence's offsets in the read it ended in. start is negative when the
+// sequence started in a previous read
+type oscSeq struct {
+	payload string
+	start   int
+	end     int
+}
+
+// parse returns the complete OSC sequences in b. The payload of a sequence is
+// the part between "ESC ]" and the terminator, e.g. "7;file://host/path"
+func (p *oscParser) parse(b []byte) []oscSeq {
+	var ret []oscSeq
+	data := b
+	// pos is the offset of data[0] in b
+	pos := 0
+	if len(p.partial) > 0 {
+		data = append(p.partial, b...)
+		pos = -len(p.partial)
+		p.partial = nil
+	}
+	for len(data) > 0 {
+		start := bytes.Index(data, []byte("\x1b]"))
+		if start == -1 {
+			// an escape at the end may be the start of the next sequence
+			if data[len(data)-1] == '\x1b' {
+				p.partial = []byte{'\x1b'}
+			}
+			break
+		}
+		end, l := oscEnd(data[start+2:])
+		if end == -1 {
+			if len(data)-start < maxOSCLen {
+				p.partial = append([]byte{}, data[start:]...)
+			}
+			break
+		}
+		end += start + 2
+		ret = append(ret, oscSeq{
+			payload: string(data[start+2 : end]),
+			start:   pos + start,
+			end:     pos + end + l,
+		})
+		data = data[end+l:]
+		pos += end + l
+	}
+	return ret
+}
+
+// oscEnd returns the index & length of the sequence terminator, BEL or ST
+func oscEnd(data []byte) (int, int) {
+	for i, c := range data {
+		if c == '\x07' {
+			return i, 1
+		}
+		if c == '\x1b' && i+1 < len(data) && data[i+1] == '\\' {
+			return i, 2
+		}
+	}
+	return -1, 0
+}
+
+// handleOutput looks for OSC sequences in the pane's output and updates the
+// pane's state. It's called after b was added to the pane's buffer.
+func (pane *Pane) handleOutput(b []byte) {
+	base := pane.Buffer.Offset() - int64(len(b))
+	seqs := pane.osc.parse(b)
+	for _, seq := range seqs {
+		cmd, arg, _ := strings.Cut(seq.payload, ";")
+		switch cmd {
+		case "9", "777":
+			pane.handleNotifySeq(cmd, arg)
+		case "133":
+			pane.handleShellMark(arg, base+int64(seq.start), base+int64(seq.end))
+		case "7":
+			u, err := url.Parse(arg)
+			if err != nil || u.Scheme != "file" || u.Path == "" {
+				pane.conf.Logger.Warnf("@%d: got a bad OSC 7 url: %q", pane.ID, arg)
+				continue
+			}
+			pane.Lock()
+			pane.cwdFromOSC = true
+			pane.Unlock()
+			pane.setCwd(u.Path)
+		}
+	}
+	if hasBell(b, seqs, len(pane.osc.partial)) {
+		pane.handleBell()
+	}
+	pane.matchTriggers(b)
+	pane.checkCwd()
+}
+
+// setCwd updates the pane's working directory and lets the peers know
+func (pane *Pane) setCwd(cwd string) {
+	pane.Lock()
+	changed := cwd != pane.cwd
+	pane.cwd = cwd
+	pane.Unlock()
+	if changed {
+		pane.conf.Logger.Infof("@%d: cwd changed to %q", pane.ID, cwd)
+		BroadcastConnected("pane_cwd", &PaneCwdArgs{PaneID: pane.ID, Cwd: cwd})
+	}
+}
+
+// checkCwd is used for shells that don't send OSC 7. It reads the cwd of the
+// foreground process, at most once every cwdCheckInterval
+func (pane *Pane) checkCwd() {
+	pane.Lock()
+	if pane.cwdFromOSC || time.Since(pane.cwdChecked) < cwdCheckInterval {
+		pane.Unlock()
+		return
+	}
+	pane.cwdChecked = time.Now()
+	pane.Unlock()
+	cwd, err := pane.procCwd()
+	if err == nil {
+		pane.setCwd(cwd)
+	}
+}
+
+// CurrentCwd returns the pane's working directory as reported by the shell
+// using OSC 7 or, if it doesn't, the cwd of the foreground process
+func (pane *Pane) CurrentCwd() (string, error) {
+	pane.Lock()
+	cwd := pane.cwd
+	fromOSC := pane.cwdFromOSC
+	pane.Unlock()
+	if fromOSC {
+		return cwd, nil
+	}
+	return pane.procCwd()
+}
+
+// procCwd returns the cwd of the pane's foreground process
+func (pane *Pane) procCwd() (string, error) {
+	proc := pane.process()
+	if proc == nil {
+		return "", fmt.Errorf("pane %d is not running", pane.ID)
+	}
+	pid := proc.Pid
+	pgrp, err := pane.foregroundPgrp()
+	if err == nil {
+		pid = pgrp
+	}
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return "", fmt.Errorf("Failed to find the pane's process: %s", err)
+	}
+	return p.Cwd()
+}
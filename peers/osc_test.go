@@ -0,0 +1,51 @@
+package peers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestOSCParser(t *testing.T) {
+	var p oscParser
+	require.Equal(t, []oscSeq{{"2;title", 5, 15}},
+		p.parse([]byte("hello\x1b]2;title\x07world")))
+	require.Empty(t, p.parse([]byte("abc\x1b]7;file://host")))
+	require.Equal(t, []oscSeq{{"7;file://host/tmp", -15, 6}, {"133;A", 8, 16}},
+		p.parse([]byte("/tmp\x1b\\$ \x1b]133;A\x07")))
+	// the sequence's start is split between reads
+	require.Empty(t, p.parse([]byte("abc\x1b")))
+	require.Equal(t, []oscSeq{{"0;x", -1, 5}}, p.parse([]byte("]0;x\x07")))
+	require.Empty(t, p.partial)
+}
+
+func TestPaneCwd(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	parent, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(parent.ID)
+	dir := t.TempDir()
+	require.NoError(t, parent.Run([]string{"sh", "-c",
+		"printf '\\033]7;file://host" + dir + "\\007'; sleep 1"}))
+	defer parent.Kill()
+	for i := 0; i < 40; i++ {
+		cwd, _ := parent.CurrentCwd()
+		if cwd == dir {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cwd, err := parent.CurrentCwd()
+	require.NoError(t, err)
+	require.Equal(t, dir, cwd)
+	// the child starts in the parent's cwd
+	child, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, parent.ID)
+	require.NoError(t, err)
+	defer Panes.Delete(child.ID)
+	require.NoError(t, child.Run([]string{"true"}))
+	require.Equal(t, dir, child.C.Dir)
+}
@@ -8,13 +8,16 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sort"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"github.com/pion/webrtc/v3"
 	"github.com/shirou/gopsutil/v3/process"
 	"github.com/tuzig/vt10x"
+	"golang.org/x/sys/unix"
 )
 
 const OutBufSize = 4096
@@ -25,8 +28,7 @@ var Panes = NewPanesDB()
 // Pane type hold a command, a pseudo tty and the connected data channels
 type Pane struct {
 	sync.Mutex
-	ID     int
-	parent int
+	ID int
 	// C holds the exectuted command
 	C            *exec.Cmd
 	IsRunning    bool
@@ -37,39 +39,70 @@ type Pane struct {
 	outbuf       chan []byte
 	cancelRWLoop context.CancelFunc
 	ctx          context.Context
-	peer         *Peer
+	conf         *Conf
+	started      time.Time
+	created      time.Time
+	lastOutput   time.Time
+	title        string
+	name         string
+	parentID     int
+	// parentCwd is the parent's cwd, relative Cwd paths start there
+	parentCwd string
+	// cwd is the tracked working directory, see osc.go
+	cwd        string
+	cwdFromOSC bool
+	cwdChecked time.Time
+	osc        oscParser
+	shell      shellState
+	notify     *notifyWatch
+	triggers   triggerState
+	// subs are channels that get a copy of the pane's output
+	subs    map[int]chan []byte
+	lastSub int
+	// Cwd, Env & EnvUnset are used by Run, on top of the configuration's env
+	Cwd      string
+	Env      map[string]string
+	EnvUnset []string
+	// Restart is the policy applied when the pane's process exits
+	Restart    RestartPolicy
+	command    []string
+	restarts   int
+	restarting bool
+	// exited is closed once the current process exited & the restart decision
+	// was made
+	exited chan struct{}
+	// senderDone is closed when the current sender goroutine exits
+	senderDone chan struct{}
+	// reaped is set once the current process exited, its session is left
+	// alone from then on
+	reaped bool
 }
 
 // ExecCommand in ahelper function for executing a command
-func ExecCommand(command []string, env map[string]string, ws *pty.Winsize, pID int, fp string) (*exec.Cmd, io.ReadWriteCloser, error) {
+func ExecCommand(command []string, env map[string]string, unset []string, ws *pty.Winsize, cwd string) (*exec.Cmd, io.ReadWriteCloser, error) {
 
 	var (
 		tty *os.File
 		dir string
 		err error
-		pwd string
 	)
 	cmd := exec.Command(command[0], command[1:]...)
-	if pID != 0 {
-		p, err := process.NewProcess(int32(pID))
-		if err != nil {
-			return nil, nil, fmt.Errorf("Failed to find parent pane's process: %s %s", err, fp)
-		}
-		pwd, err = p.Cwd()
-		if err != nil {
-			return nil, nil, fmt.Errorf("Failed getting parent pane's cwd: %s %s", err, fp)
-		}
-		dir = pwd
-	} else {
-		dir, err = os.UserHomeDir()
+	dir, err = os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cwd != "" {
+		dir, err = resolveDir(dir, cwd)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, fmt.Errorf("Bad working directory %q: %s", cwd, err)
 		}
 	}
 	cmd.Dir = dir
 	if env != nil {
 		for k, v := range env {
-			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+			if !containsString(unset, k) {
+				cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+			}
 		}
 	}
 	if ws != nil {
@@ -78,35 +111,38 @@ func ExecCommand(command []string, env map[string]string, ws *pty.Winsize, pID i
 		tty, err = PtyMux.Start(cmd)
 	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("Failed launching %q: %q %s", command, err, fp)
+		return nil, nil, fmt.Errorf("Failed launching %q: %q", command, err)
 	}
-	go func() {
-		cmd.Process.Wait()
-	}()
-
 	return cmd, tty, nil
 }
 
-// NewPane opens a new pane
-func NewPane(peer *Peer, ws *pty.Winsize, parent int) (*Pane, error) {
+// NewPane opens a new pane. The pane is not tied to the peer that requested
+// it so it uses the given conf for logging and running the command
+func NewPane(conf *Conf, ws *pty.Winsize, parent int) (*Pane, error) {
 
 	var vt vt10x.Terminal
+	var parentCwd string
 	if parent != 0 {
 		parentPane := Panes.Get(parent)
 		if parentPane == nil {
 			return nil, fmt.Errorf(
 				"Got a pane request with an illegal parrent pane id: %d", parent)
 		}
-		//TODO: handle a crash here, when there's a parent pane but no process yet
-		//      https://github.com/tuzig/webexec/issues/106
-		parent = parentPane.C.Process.Pid
+		cwd, err := parentPane.CurrentCwd()
+		if err != nil {
+			conf.Logger.Warnf("Failed to get parent pane's cwd, using home: %s", err)
+		} else {
+			parentCwd = cwd
+		}
 	}
 	if ws != nil {
 		vt = vt10x.New(vt10x.WithSize(int(ws.Cols), int(ws.Rows)))
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	pane := &Pane{
-		parent:       parent,
+		parentID:     parent,
+		parentCwd:    parentCwd,
+		created:      time.Now(),
 		IsRunning:    false,
 		Buffer:       NewBuffer(100000), //TODO: get the number from conf
 		Ws:           ws,
@@ -114,40 +150,233 @@ func NewPane(peer *Peer, ws *pty.Winsize, parent int) (*Pane, error) {
 		outbuf:       make(chan []byte, OutBufSize),
 		ctx:          ctx,
 		cancelRWLoop: cancel,
-		peer:         peer,
+		conf:         conf,
 	}
 	Panes.Add(pane) // This will set pane.ID
 	return pane, nil
 }
 
-// start starts the command and pty
+// Run starts the command and pty
 func (pane *Pane) Run(command []string) error {
-	logger := pane.peer.logger
-	run := pane.peer.Conf.RunCommand
+	pane.command = command
+	return pane.start()
+}
+
+// start runs the pane's command in a new pty
+func (pane *Pane) start() error {
+	logger := pane.conf.Logger
+	command := pane.command
+	run := pane.conf.RunCommand
 	if run == nil {
 		run = ExecCommand
 	}
 	logger.Infof("Starting command: %v", command)
-	cmd, tty, err := run(
-		command, pane.peer.Conf.Env, pane.Ws, pane.parent, pane.peer.FP)
+	env := pane.conf.baseEnv()
+	for k, v := range pane.conf.Env {
+		env[k] = v
+	}
+	for k, v := range pane.Env {
+		env[k] = v
+	}
+	cwd := pane.Cwd
+	if pane.parentCwd != "" {
+		dir, err := resolveDir(pane.parentCwd, cwd)
+		if err != nil {
+			return fmt.Errorf("Bad working directory %q: %s", pane.Cwd, err)
+		}
+		cwd = dir
+	}
+	cmd, tty, err := run(command, env, pane.EnvUnset, pane.Ws, cwd)
 	if err != nil {
 		logger.Warnf("command failed: %s", err)
 		return err
 	}
-	pane.C = cmd
+	var exited chan struct{}
+	if cmd != nil {
+		exited = make(chan struct{})
+	}
 	pane.Lock()
+	pane.C = cmd
+	pane.reaped = false
 	pane.IsRunning = true
-	pane.Unlock()
+	pane.started = time.Now()
+	pane.exited = exited
 	pane.TTY = tty
+	pane.Unlock()
 	errbuf := new(bytes.Buffer)
 	if cmd != nil {
 		cmd.Stderr = errbuf
+		go pane.wait(cmd, exited)
 	}
 	go pane.stderrLoop(errbuf)
 	go pane.ReadLoop()
 	return nil
 }
 
+// wait reaps the pane's process, lets the peers know how it ended and restarts
+// it if the restart policy says so
+func (pane *Pane) wait(cmd *exec.Cmd, exited chan struct{}) {
+	logger := pane.conf.Logger
+	err := cmd.Wait()
+	pane.Lock()
+	if pane.C == cmd {
+		pane.reaped = true
+	}
+	pane.Unlock()
+	ps := cmd.ProcessState
+	if ps == nil {
+		logger.Errorf("@%d: Failed waiting for process: %s", pane.ID, err)
+		close(exited)
+		return
+	}
+	args := PaneExitedArgs{
+		PaneID:   pane.ID,
+		ExitCode: ps.ExitCode(),
+		Runtime:  time.Since(pane.started).Milliseconds(),
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		args.Signal = unix.SignalName(ws.Signal())
+	}
+	logger.Infof("@%d: process exited: %s", pane.ID, ps)
+	BroadcastAll("pane_exited", &args)
+	pane.Lock()
+	// a process that ran long enough starts a new series of restarts
+	if time.Since(pane.started) > healthyRuntime {
+		pane.restarts = 0
+	}
+	pane.restarting = pane.IsRunning &&
+		pane.Restart.shouldRestart(args.ExitCode, pane.restarts)
+	restart := pane.restarting
+	pane.Unlock()
+	close(exited)
+	if restart {
+		pane.restart(cmd, args.ExitCode)
+	}
+}
+
+// restart waits for the backoff delay and runs the pane's command again. The
+// buffer, the screen & the data channels are kept so clients see the new
+// process's output right after a separator line.
+func (pane *Pane) restart(prev *exec.Cmd, exitCode int) {
+	logger := pane.conf.Logger
+	pane.Lock()
+	pane.restarts++
+	n := pane.restarts
+	pane.Unlock()
+	delay := pane.Restart.delay(n)
+	logger.Infof("@%d: restarting in %s", pane.ID, delay)
+	select {
+	case <-pane.ctx.Done():
+		return
+	case <-time.After(delay):
+	}
+	tty := pane.GetTTY()
+	// only one sender may read the output, or it could be sent out of order
+	pane.Lock()
+	done := pane.senderDone
+	pane.Unlock()
+	if done != nil {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			// a child that inherited the tty keeps the read loop going
+			if tty != nil {
+				tty.Close()
+			}
+			<-done
+		}
+	}
+	pane.outbuf <- []byte(fmt.Sprintf(
+		"\r\n\x1b[7m[webexec: exited with code %d, restart #%d]\x1b[0m\r\n",
+		exitCode, n))
+	// the new process starts where the old one was
+	pane.parentCwd = ""
+	pane.Cwd = prev.Dir
+	err := pane.start()
+	pane.Lock()
+	pane.restarting = false
+	pane.Unlock()
+	if tty != nil {
+		tty.Close()
+	}
+	if err != nil {
+		logger.Errorf("@%d: failed to restart: %s", pane.ID, err)
+		pane.Kill()
+		return
+	}
+	BroadcastAll("pane_restarted", &PaneRestartedArgs{PaneID: pane.ID, Restarts: n})
+}
+
+// Done returns a channel that's closed when the pane is killed
+func (pane *Pane) Done() <-chan struct{} {
+	return pane.ctx.Done()
+}
+
+// LastPeer returns the connected peer that was the last to attach to the pane,
+// or nil if no connected peer is attached
+func (pane *Pane) LastPeer() *Peer {
+	var last *Client
+	for _, c := range CDB.All4Pane(pane) {
+		if c.peer == nil || c.peer.PC == nil ||
+			c.peer.PC.ConnectionState() != webrtc.PeerConnectionStateConnected {
+			continue
+		}
+		if last == nil || c.id > last.id {
+			last = c
+		}
+	}
+	if last == nil {
+		return nil
+	}
+	return last.peer
+}
+
+// Info returns the pane's details
+func (pane *Pane) Info() PaneInfo {
+	pane.Lock()
+	defer pane.Unlock()
+	clients := CDB.All4Pane(pane)
+	info := PaneInfo{
+		ID:      pane.ID,
+		Name:    pane.name,
+		Command: pane.command,
+		Running: pane.IsRunning,
+		Title:   pane.title,
+		Created: pane.created.UnixMilli(),
+		Parent:  pane.parentID,
+		Clients: len(clients),
+	}
+	fps := map[string]bool{}
+	for _, c := range clients {
+		fp := "local"
+		if c.peer != nil {
+			fp = c.peer.FP
+		}
+		if !fps[fp] {
+			fps[fp] = true
+			info.Peers = append(info.Peers, fp)
+		}
+	}
+	sort.Strings(info.Peers)
+	if pane.Ws != nil {
+		info.Rows = pane.Ws.Rows
+		info.Cols = pane.Ws.Cols
+	}
+	if !pane.lastOutput.IsZero() {
+		info.LastOutput = pane.lastOutput.UnixMilli()
+	}
+	return info
+}
+
+// SetName sets the pane's name and notifies the connected peers
+func (pane *Pane) SetName(name string) {
+	pane.Lock()
+	pane.name = name
+	pane.Unlock()
+	pane.conf.Logger.Infof("@%d: renamed to %q", pane.ID, name)
+	BroadcastConnected("pane_renamed", &PaneRenamedArgs{PaneID: pane.ID, Name: name})
+}
+
 // sendFirstMessage sends the pane id and dimensions
 func (pane *Pane) sendFirstMessage(dc *webrtc.DataChannel) {
 	var r string
@@ -161,12 +390,20 @@ func (pane *Pane) sendFirstMessage(dc *webrtc.DataChannel) {
 
 // ReadLoop reads the tty and send data it finds to the open data channels
 func (pane *Pane) ReadLoop() {
-	logger := pane.peer.logger
+	logger := pane.conf.Logger
 	conNull := 0
 	id := pane.ID
+	pane.Lock()
+	exited := pane.exited
+	pane.Unlock()
 	sctx, cancel := context.WithCancel(context.Background())
-	go pane.sender(sctx)
-	logger.Infof("readding from tty: %v", pane.TTY)
+	done := make(chan struct{})
+	pane.Lock()
+	pane.senderDone = done
+	pane.Unlock()
+	go pane.sender(sctx, done)
+	tty := pane.GetTTY()
+	logger.Infof("readding from tty: %v", tty)
 loop:
 	for {
 		select {
@@ -175,7 +412,7 @@ loop:
 		default:
 		}
 		b := make([]byte, OutBufSize)
-		l, rerr := pane.TTY.Read(b)
+		l, rerr := tty.Read(b)
 		if rerr == io.EOF {
 			logger.Infof("@%d: got EOF in read loop", pane.ID)
 			break loop
@@ -202,13 +439,30 @@ loop:
 	// TODO: find a better way to wait for all the messages to be sent
 	time.AfterFunc(time.Second/10, func() {
 		cancel()
+		// give the process a chance to exit so a restart can be decided
+		if exited != nil {
+			select {
+			case <-exited:
+			case <-time.After(time.Second):
+			}
+		}
 		pane = Panes.Get(id)
-		pane.Kill()
+		if pane == nil {
+			return
+		}
+		pane.Lock()
+		// the pane is kept when it's restarting or already restarted
+		restarted := pane.restarting || pane.exited != exited
+		pane.Unlock()
+		if !restarted {
+			pane.Kill()
+		}
 	})
 }
 
-func (pane *Pane) sender(ctx context.Context) {
-	logger := pane.peer.logger
+func (pane *Pane) sender(ctx context.Context, done chan struct{}) {
+	logger := pane.conf.Logger
+	defer close(done)
 loop:
 	for {
 		select {
@@ -236,16 +490,71 @@ loop:
 			}
 			if pane.vt != nil {
 				pane.vt.Write(m)
+				pane.updateTitle()
 			}
 			pane.Buffer.Add(m)
+			pane.handleOutput(m)
+			pane.Lock()
+			pane.lastOutput = time.Now()
+			for id, c := range pane.subs {
+				select {
+				case c <- m:
+				default:
+					logger.Warnf("@%d: subscriber %d is too slow, dropping output", pane.ID, id)
+				}
+			}
+			pane.Unlock()
 		}
 	}
 	logger.Infof("Exiting the sender loop for pane %d ", pane.ID)
 }
 
+// updateTitle checks if the output changed the title, using OSC 0 or 2, and
+// lets the peers know
+func (pane *Pane) updateTitle() {
+	pane.vt.Lock()
+	title := pane.vt.Title()
+	pane.vt.Unlock()
+	pane.Lock()
+	changed := title != pane.title
+	pane.title = title
+	pane.Unlock()
+	if changed {
+		pane.conf.Logger.Infof("@%d: title changed to %q", pane.ID, title)
+		BroadcastConnected("pane_title", &PaneTitleArgs{PaneID: pane.ID, Title: title})
+	}
+}
+
+// titleSeq returns the escape sequence that sets the pane's title
+func (pane *Pane) titleSeq() []byte {
+	pane.Lock()
+	defer pane.Unlock()
+	if pane.title == "" {
+		return nil
+	}
+	return []byte(fmt.Sprintf("\x1b]2;%s\x07", pane.title))
+}
+
+// KillAndNotify kills the pane after sending a pane_killed message to the
+// peers attached to it
+func (pane *Pane) KillAndNotify() {
+	notified := map[*Peer]bool{}
+	for _, c := range CDB.All4Pane(pane) {
+		if c.peer == nil || notified[c.peer] {
+			continue
+		}
+		notified[c.peer] = true
+		err := c.peer.sendOrQueue("pane_killed", &PaneKilledArgs{PaneID: pane.ID})
+		if err != nil {
+			pane.conf.Logger.Warnf("Failed to send pane_killed: %s", err)
+		}
+	}
+	pane.Kill()
+}
+
 // Kill takes a pane to the sands of Rishon and buries it
 func (pane *Pane) Kill() {
-	logger := pane.peer.logger
+	logger := pane.conf.Logger
 	logger.Infof("Killing a pane")
 	for _, d := range CDB.All4Pane(pane) {
 		if d.dc.ReadyState() == webrtc.DataChannelStateOpen {
@@ -255,84 +564,264 @@ func (pane *Pane) Kill() {
 	}
 	pane.Lock()
 	defer pane.Unlock()
+	for id, c := range pane.subs {
+		close(c)
+		delete(pane.subs, id)
+	}
+	tty := pane.TTY
 	if pane.IsRunning {
 		pane.cancelRWLoop()
-		if pane.C != nil {
-			err := pane.C.Process.Kill()
-			if err != nil {
-				logger.Errorf("Failed to kill process: %v %v",
-					err, pane.C.ProcessState.String())
+		pane.IsRunning = false
+		// the tty is closed only after termination so the processes get
+		// the configured signal and not a hangup
+		go func() {
+			pane.terminate()
+			if tty != nil {
+				tty.Close()
 			}
+		}()
+		return
+	}
+	if tty != nil {
+		tty.Close()
+	}
+}
+
+// GetTTY returns the pane's pseudo tty or nil if it has none. The tty is
+// replaced when the pane's process is restarted
+func (pane *Pane) GetTTY() io.ReadWriteCloser {
+	pane.Lock()
+	defer pane.Unlock()
+	return pane.TTY
+}
+
+// process returns the pane's running process or nil if it's not running
+func (pane *Pane) process() *os.Process {
+	pane.Lock()
+	defer pane.Unlock()
+	if !pane.IsRunning || pane.C == nil {
+		return nil
+	}
+	return pane.C.Process
+}
+
+// terminate sends the kill signal to all the processes in the pane's session,
+// waits for the grace period and SIGKILLs whatever is left
+func (pane *Pane) terminate() {
+	pane.Lock()
+	cmd := pane.C
+	reaped := pane.reaped
+	pane.Unlock()
+	// processes left by a process that exited on its own, like the jobs a
+	// shell started with nohup, are kept running
+	if cmd == nil || cmd.Process == nil || reaped {
+		return
+	}
+	logger := pane.conf.Logger
+	sig := pane.conf.KillSignal
+	if sig == 0 {
+		sig = syscall.SIGKILL
+	}
+	// the pane's process is a session leader so the session id is its pid
+	exited, killed := terminateSession(
+		cmd.Process.Pid, sig, pane.conf.KillGrace)
+	if len(exited) > 0 || len(killed) > 0 {
+		logger.Infof("@%d: processes %v exited on %s, %v were killed",
+			pane.ID, exited, sig, killed)
+	}
+}
+
+// Subscribe returns a channel that gets the pane's output and a function to
+// call when done. The channel is closed when the pane is killed
+func (pane *Pane) Subscribe() (<-chan []byte, func()) {
+	c := make(chan []byte, OutBufSize)
+	pane.Lock()
+	defer pane.Unlock()
+	if pane.subs == nil {
+		pane.subs = make(map[int]chan []byte)
+	}
+	pane.lastSub++
+	id := pane.lastSub
+	pane.subs[id] = c
+	return c, func() {
+		pane.Lock()
+		defer pane.Unlock()
+		if _, ok := pane.subs[id]; ok {
+			close(c)
+			delete(pane.subs, id)
 		}
-		pane.IsRunning = false
 	}
-	if pane.TTY != nil {
-		pane.TTY.Close()
+}
+
+// SendInput writes input to the pane's tty, as if it was typed
+func (pane *Pane) SendInput(b []byte) error {
+	pane.Lock()
+	running := pane.IsRunning
+	pane.Unlock()
+	if !running {
+		return fmt.Errorf("pane %d is not running", pane.ID)
 	}
+	return pane.write(b)
 }
 
 // OnMessage is called when a new client message is recieved
 func (pane *Pane) OnMessage(msg webrtc.DataChannelMessage) {
-	logger := pane.peer.logger
-	p := msg.Data
-	l, err := pane.TTY.Write(p)
+	pane.write(msg.Data)
+}
+
+// write writes input to the pane's tty
+func (pane *Pane) write(p []byte) error {
+	logger := pane.conf.Logger
+	tty := pane.GetTTY()
+	if tty == nil {
+		return fmt.Errorf("pane %d has no tty", pane.ID)
+	}
+	l, err := tty.Write(p)
 	if err == os.ErrClosed {
 		logger.Infof("got an os.ErrClosed")
 		pane.Kill()
-		return
+		return err
 	}
 	if err != nil {
 		logger.Warnf("pty of %d write failed: %v",
 			pane.ID, err)
+		return err
 	}
 	if l != len(p) {
 		logger.Warnf("pty of %d wrote %d instead of %d bytes",
 			pane.ID, l, len(p))
 	}
+	return nil
 }
 
-// Resize is used to resize the pane's tty.
-// the function does nothing if it's given a nil size or the current size
-func (pane *Pane) Resize(ws *pty.Winsize) {
-	logger := pane.peer.logger
-	if ws != nil && (ws.Rows != pane.Ws.Rows || ws.Cols != pane.Ws.Cols) {
-		logger.Infof("Changing pty size for pane %d: %v", pane.ID, ws)
-		pane.Ws = ws
-		pty.Setsize(pane.TTY.(*os.File), ws)
-		if pane.vt != nil {
-			pane.vt.Resize(int(ws.Cols), int(ws.Rows))
+// Signal sends a signal to the pane's process. When group is true the signal
+// is sent to the foreground process group of the pane's tty
+func (pane *Pane) Signal(sig syscall.Signal, group bool) error {
+	p := pane.process()
+	if p == nil {
+		return fmt.Errorf("pane %d has no process", pane.ID)
+	}
+	if !group {
+		return p.Signal(sig)
+	}
+	pgrp, err := pane.foregroundPgrp()
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(-pgrp, sig)
+}
+
+// foregroundPgrp returns the id of the pty's foreground process group
+func (pane *Pane) foregroundPgrp() (int, error) {
+	f, ok := pane.GetTTY().(*os.File)
+	if !ok {
+		return 0, fmt.Errorf("pane %d has no pseudo tty", pane.ID)
+	}
+	pgrp, err := unix.IoctlGetInt(int(f.Fd()), unix.TIOCGPGRP)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get the foreground process group: %s", err)
+	}
+	return pgrp, nil
+}
+
+// ProcessInfo returns the foreground process, the cwd and the resource usage
+// of the pane's processes
+func (pane *Pane) ProcessInfo() (*PaneProcessInfo, error) {
+	proc := pane.process()
+	if proc == nil {
+		return nil, fmt.Errorf("pane %d is not running", pane.ID)
+	}
+	pid := proc.Pid
+	info := PaneProcessInfo{PaneID: pane.ID}
+	pgrp, err := pane.foregroundPgrp()
+	if err != nil {
+		return nil, err
+	}
+	info.ForegroundPID = pgrp
+	p, err := process.NewProcess(int32(pgrp))
+	if err == nil {
+		info.Foreground, _ = p.Name()
+	}
+	info.Cwd, err = pane.CurrentCwd()
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting the pane's cwd: %s", err)
+	}
+	// the pane's process is a session leader so the session holds the tree
+	for _, spid := range sessionPids(pid) {
+		sp, err := process.NewProcess(int32(spid))
+		if err != nil {
+			continue
+		}
+		cpu, err := sp.CPUPercent()
+		if err == nil {
+			info.CPU += cpu
+		}
+		mem, err := sp.MemoryInfo()
+		if err == nil {
+			info.RSS += mem.RSS
 		}
 	}
+	return &info, nil
 }
 
-func (pane *Pane) dumpVT() []byte {
+// Resize is used to resize the pane's tty. It returns true if the size was
+// changed, the function does nothing if it's given a nil size or the current
+// size
+func (pane *Pane) Resize(ws *pty.Winsize) bool {
+	if ws == nil {
+		return false
+	}
+	pane.Lock()
+	changed := pane.Ws == nil || ws.Rows != pane.Ws.Rows || ws.Cols != pane.Ws.Cols
+	if changed {
+		pane.Ws = ws
+	}
+	pane.Unlock()
+	if !changed {
+		return false
+	}
+	pane.conf.Logger.Infof("Changing pty size for pane %d: %v", pane.ID, ws)
+	if f, ok := pane.GetTTY().(*os.File); ok {
+		pty.Setsize(f, ws)
+	}
+	if pane.vt != nil {
+		pane.vt.Resize(int(ws.Cols), int(ws.Rows))
+	}
+	return true
+}
 
-	var result string
-	var prevFG, prevBG vt10x.Color
+// NotifyResize sends a resize message with the pane's size to the connected
+// peers attached to the pane, so they draw at the new size
+func (pane *Pane) NotifyResize() {
+	pane.Lock()
+	ws := pane.Ws
+	pane.Unlock()
+	if ws == nil {
+		return
+	}
+	args := ResizeArgs{PaneID: pane.ID, Sx: ws.Cols, Sy: ws.Rows}
+	sent := map[*Peer]bool{}
+	for _, c := range CDB.All4Pane(pane) {
+		if c.peer == nil || sent[c.peer] || !c.peer.isConnected() {
+			continue
+		}
+		sent[c.peer] = true
+		err := c.peer.SendControlMessage("resize", &args)
+		if err != nil {
+			pane.conf.Logger.Warnf("Failed to send a resize message: %v", err)
+		}
+	}
+}
 
+func (pane *Pane) dumpVT() []byte {
 	t := pane.vt
 	t.Lock()
 	defer t.Unlock()
-	cols, rows := t.Size()
-	for y := 0; y < rows; y++ {
-		for x := 0; x < cols; x++ {
-			glyph := t.Cell(x, y)
-			if glyph.FG != prevFG || glyph.BG != prevBG {
-				result += printColorChange(glyph.FG, glyph.BG)
-				prevFG = glyph.FG
-				prevBG = glyph.BG
-			}
-			// TODO: Handle attributes such as bold, italic, underline, etc. using glyph.Mode
-			result += string(glyph.Char)
-		}
-		if y < rows-1 {
-			result += "\r\n"
-		}
-	}
+	result := renderScreen(t, true, "\r\n")
 	c := t.Cursor()
 	result += fmt.Sprintf("\x1b[%d;%dH", c.Y+1, c.X+1)
 
-	pane.peer.logger.Infof("Sending %d bytes of screen dump", len(result))
+	pane.conf.Logger.Infof("Sending %d bytes of screen dump", len(result))
 	return []byte(result)
 }
 
@@ -357,12 +846,21 @@ func printColorChange(fg, bg vt10x.Color) string {
 	return ret
 }
 
+// Dump returns the data that restores the pane's screen & title. Panes with no
+// terminal emulator return their buffer
+func (pane *Pane) Dump() []byte {
+	if pane.vt == nil {
+		return append(pane.titleSeq(), pane.Buffer.Tail(0)...)
+	}
+	return append(pane.titleSeq(), pane.dumpVT()...)
+}
+
 // Restore restore the screen or buffer.
 // If the peer has a marker data will be read from the buffer and sent over.
 // If no marker, Restore uses our headless terminal emulator to restore the
 // screen.
 func (pane *Pane) Restore(d *webrtc.DataChannel, marker int) {
-	logger := pane.peer.logger
+	logger := pane.conf.Logger
 	if marker == -1 {
 		if pane.vt != nil {
 			id := d.ID()
@@ -374,7 +872,7 @@ func (pane *Pane) Restore(d *webrtc.DataChannel, marker int) {
 				"Sending scrren dump to pane: %d, dc: %d", pane.ID, *id)
 			//TODO: this and the next afterfunc is silly
 			time.AfterFunc(time.Second/10, func() {
-				d.Send(pane.dumpVT())
+				d.Send(pane.Dump())
 			})
 		} else {
 			logger.Warn("not restoring as st is null")
@@ -382,12 +880,12 @@ func (pane *Pane) Restore(d *webrtc.DataChannel, marker int) {
 	} else {
 		logger.Infof("Sending history buffer since marker: %d", marker)
 		time.AfterFunc(time.Second/10, func() {
-			d.Send(pane.Buffer.GetSinceMarker(marker))
+			d.Send(append(pane.titleSeq(), pane.Buffer.GetSinceMarker(marker)...))
 		})
 	}
 }
 func (pane *Pane) stderrLoop(errors *bytes.Buffer) {
-	logger := pane.peer.logger
+	logger := pane.conf.Logger
 loop:
 	for {
 		select {
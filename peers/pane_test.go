@@ -0,0 +1,94 @@
+package peers
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPaneTitle(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	require.Nil(t, pane.titleSeq())
+	require.NoError(t, pane.Run([]string{"printf", "\x1b]2;vim - ~/src\x07hello"}))
+	for i := 0; i < 40 && pane.Info().Title == ""; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Equal(t, "vim - ~/src", pane.Info().Title)
+	require.Equal(t, "\x1b]2;vim - ~/src\x07", string(pane.titleSeq()))
+}
+
+// fakeChannel is a client channel that records the data sent to it
+type fakeChannel struct {
+	sync.Mutex
+	closed bool
+	sent   [][]byte
+}
+
+func (c *fakeChannel) ReadyState() webrtc.DataChannelState {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return webrtc.DataChannelStateClosed
+	}
+	return webrtc.DataChannelStateOpen
+}
+func (c *fakeChannel) Send(b []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	c.sent = append(c.sent, b)
+	return nil
+}
+func (c *fakeChannel) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	return nil
+}
+
+// messages returns the control messages sent to the channel
+func (c *fakeChannel) messages() [][]byte {
+	c.Lock()
+	defer c.Unlock()
+	return append([][]byte(nil), c.sent...)
+}
+
+func TestPaneKillAndNotify(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	require.NoError(t, pane.Run([]string{"cat"}))
+	peer := &Peer{FP: "kill-test", logger: conf.Logger}
+	remote := &fakeChannel{}
+	local := &fakeChannel{}
+	CDB.Add(remote, pane, peer)
+	CDB.Add(local, pane, nil)
+	pane.SetName("build")
+	info := pane.Info()
+	require.Equal(t, "build", info.Name)
+	require.Equal(t, 2, info.Clients)
+	require.Equal(t, []string{"kill-test", "local"}, info.Peers)
+	pane.KillAndNotify()
+	require.Equal(t, webrtc.DataChannelStateClosed, remote.ReadyState())
+	require.Equal(t, webrtc.DataChannelStateClosed, local.ReadyState())
+	require.Empty(t, CDB.All4Pane(pane))
+	peer.pendingM.Lock()
+	defer peer.pendingM.Unlock()
+	require.Len(t, peer.pending, 1)
+	var args PaneKilledArgs
+	m := CTRLMessage{Args: &args}
+	require.NoError(t, json.Unmarshal(peer.pending[0], &m))
+	require.Equal(t, "pane_killed", m.Type)
+	require.Equal(t, pane.ID, args.PaneID)
+}
@@ -2,6 +2,7 @@ package peers
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
@@ -40,6 +41,17 @@ func (pd *PanesDB) All() []*Pane {
 
 }
 
+// List returns the details of all the panes, sorted by id
+func (pd *PanesDB) List() []PaneInfo {
+	panes := pd.All()
+	sort.Slice(panes, func(i, j int) bool { return panes[i].ID < panes[j].ID })
+	ret := make([]PaneInfo, 0, len(panes))
+	for _, p := range panes {
+		ret = append(ret, p.Info())
+	}
+	return ret
+}
+
 // Delete delets a pane from the db
 func (pd *PanesDB) Delete(id int) error {
 	pd.m.Lock()
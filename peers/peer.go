@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 	"unicode"
@@ -22,8 +23,12 @@ import (
 
 const keepAliveInterval = 2 * time.Second
 
+// maxPendingMessages is the maximum number of control messages kept for a
+// disconnected peer
+const maxPendingMessages = 64
+
 // RunCommandInterface is an interface for a function that runs a command
-type RunCommandInterface func([]string, map[string]string, *pty.Winsize, int, string) (*exec.Cmd, io.ReadWriteCloser, error)
+type RunCommandInterface func([]string, map[string]string, []string, *pty.Winsize, string) (*exec.Cmd, io.ReadWriteCloser, error)
 
 var (
 	// Peers holds all the peers (connected and disconnected)
@@ -46,13 +51,18 @@ type Conf struct {
 	Certificate       *webrtc.Certificate
 	DisconnectTimeout time.Duration
 	Env               map[string]string
+	EnvPolicy         string
 	FailedTimeout     time.Duration
 	GatheringTimeout  time.Duration
 	GetICEServers     func() ([]webrtc.ICEServer, error)
 	GetWelcome        func() string
 	KeepAliveInterval time.Duration
+	KillGrace         time.Duration
+	KillSignal        syscall.Signal
 	Logger            *zap.SugaredLogger
+	OnCTRLClose       func(*Peer)
 	OnCTRLMsg         func(*Peer, *CTRLMessage, json.RawMessage)
+	OnSOCKSChannel    func(*Peer, *webrtc.DataChannel)
 	OnStateChange     func(*Peer, webrtc.PeerConnectionState)
 	PortMax           uint16
 	PortMin           uint16
@@ -70,11 +80,18 @@ type Peer struct {
 	LastContact       *time.Time
 	LastRef           int
 	PC                *webrtc.PeerConnection
-	cdc               *webrtc.DataChannel
+	cdc               ClientChannel
 	Marker            int
 	pendingCandidates chan *webrtc.ICECandidateInit
 	logger            *zap.SugaredLogger
 	Conf              *Conf
+	// pending holds control messages waiting for the peer to reconnect
+	pending [][]byte
+	// next is the peer that took over the pending messages when the client
+	// reconnected
+	next *Peer
+	// pendingM guards cdc, pending & next
+	pendingM sync.Mutex
 }
 
 // CandidatePairStats is a struct that holds the values of a ICE candidate pair
@@ -146,6 +163,15 @@ func NewPeer(fp string, conf *Conf) (*Peer, error) {
 	if Peers == nil {
 		Peers = make(map[string]*Peer)
 	}
+	// a reconnecting peer gets the messages sent while it was away
+	old, ok := Peers[fp]
+	if ok {
+		old.pendingM.Lock()
+		peer.pending = old.pending
+		old.pending = nil
+		old.next = &peer
+		old.pendingM.Unlock()
+	}
 	Peers[fp] = &peer
 	peersM.Unlock()
 	// Status changes happend when the peer has connected/disconnected
@@ -206,6 +232,17 @@ func (peer *Peer) OnChannelReq(d *webrtc.DataChannel) {
 	}
 	label := d.Label()
 	peer.logger.Infof("Got a channel request: channel label %q", label)
+	// "socks" channels carry a SOCKS5 connection to the host's network.
+	// The handler is set before the channel opens, so no data is lost
+	if label == "socks" {
+		if peer.Conf.OnSOCKSChannel == nil {
+			peer.logger.Warn("Closing a socks channel, SOCKS is not supported")
+			d.Close()
+			return
+		}
+		peer.Conf.OnSOCKSChannel(peer, d)
+		return
+	}
 	if label != "%" {
 		peer.logger.Errorf("Closing client with wrong version: %s", label)
 	}
@@ -296,9 +333,15 @@ func (peer *Peer) GetOrCreatePane(d *webrtc.DataChannel) (*Pane, error) {
 	if l[0] == '%' {
 		//TODO: if there's an older cdc close it
 		peer.logger.Info("Got a request to open a control channel")
-		peer.cdc = d
 		d.OnMessage(peer.handleCTRLMsg)
+		d.OnClose(func() {
+			peer.logger.Info("The control channel was closed")
+			if peer.Conf.OnCTRLClose != nil {
+				peer.Conf.OnCTRLClose(peer)
+			}
+		})
 		peer.handleCTRLMsg(webrtc.DataChannelMessage{})
+		peer.setControlChannel(d)
 		return nil, nil
 	}
 	// if the label starts witha digit, i.e. "80x24" it needs a pty
@@ -327,7 +370,7 @@ func (peer *Peer) GetOrCreatePane(d *webrtc.DataChannel) (*Pane, error) {
 		peer.logger.Infof("Got a reconnect request to pane %d", id)
 		return peer.Reconnect(d, id)
 	}
-	pane, err = NewPane(peer, ws, 0)
+	pane, err = NewPane(peer.Conf, ws, 0)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create new pane: %q", err)
 	}
@@ -476,7 +519,34 @@ func (peer *Peer) SendControlMessageAndWait(typ string, args interface{}) (strin
 // SendMessage marshales a message and sends it over the cdc
 func (peer *Peer) SendMessage(msg []byte) error {
 	peer.logger.Infof("Sending message: %s", msg)
-	return peer.cdc.Send(msg)
+	cdc := peer.controlChannel()
+	if cdc == nil {
+		return fmt.Errorf("peer has no control channel")
+	}
+	return cdc.Send(msg)
+}
+
+// controlChannel returns the peer's control channel or nil if it has none
+func (peer *Peer) controlChannel() ClientChannel {
+	peer.pendingM.Lock()
+	defer peer.pendingM.Unlock()
+	return peer.cdc
+}
+
+// setControlChannel sets the peer's control channel and sends it the messages
+// that were queued. The lock is held while sending so newer messages can't get
+// ahead of the queued ones
+func (peer *Peer) setControlChannel(cdc ClientChannel) {
+	peer.pendingM.Lock()
+	defer peer.pendingM.Unlock()
+	peer.cdc = cdc
+	for _, msg := range peer.pending {
+		err := cdc.Send(msg)
+		if err != nil {
+			peer.logger.Warnf("Failed to send a pending message: %v", err)
+		}
+	}
+	peer.pending = nil
 }
 
 // Peer.AddCandidate adds a new ICE candidate to the peer
@@ -492,7 +562,7 @@ func (peer *Peer) AddCandidate(candidate webrtc.ICECandidateInit) error {
 
 func (peer *Peer) Broadcast(typ string, args interface{}) error {
 	for _, p := range Peers {
-		if p != peer && p.cdc != nil {
+		if p != peer && p.controlChannel() != nil {
 			err := p.SendControlMessage(typ, args)
 			if err != nil {
 				peer.logger.Warnf("Failed to send a broadcast message: %v", err)
@@ -501,6 +571,84 @@ func (peer *Peer) Broadcast(typ string, args interface{}) error {
 	}
 	return nil
 }
+
+// BroadcastAll sends a control message to all the peers. Peers that have
+// lost their control channel will get the message when they reconnect, so
+// it's used only for the panes' lifecycle events.
+func BroadcastAll(typ string, args interface{}) {
+	peersM.Lock()
+	all := make([]*Peer, 0, len(Peers))
+	for _, p := range Peers {
+		all = append(all, p)
+	}
+	peersM.Unlock()
+	for _, p := range all {
+		err := p.sendOrQueue(typ, args)
+		if err != nil {
+			p.logger.Warnf("Failed to send a broadcast message: %v", err)
+		}
+	}
+}
+
+// BroadcastConnected sends a control message to the peers with an open
+// control channel. It's used for changes in a pane's state that a
+// reconnecting peer can get from the pane's info.
+func BroadcastConnected(typ string, args interface{}) {
+	peersM.Lock()
+	connected := make([]*Peer, 0, len(Peers))
+	for _, p := range Peers {
+		if p.isConnected() {
+			connected = append(connected, p)
+		}
+	}
+	peersM.Unlock()
+	for _, p := range connected {
+		err := p.SendControlMessage(typ, args)
+		if err != nil {
+			p.logger.Warnf("Failed to send a broadcast message: %v", err)
+		}
+	}
+}
+
+// isConnected returns true if the peer's control channel is open
+func (peer *Peer) isConnected() bool {
+	peer.pendingM.Lock()
+	defer peer.pendingM.Unlock()
+	return peer.connected()
+}
+
+// connected is isConnected for callers that hold pendingM
+func (peer *Peer) connected() bool {
+	return peer.cdc != nil && peer.cdc.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+// sendOrQueue sends a control message if the control channel is open or
+// queues it to be sent when the peer reconnects
+func (peer *Peer) sendOrQueue(typ string, args interface{}) error {
+	msg := peer.newCTRLMessage(typ, args)
+	msgJ, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal the msg: %s", err)
+	}
+	peer.pendingM.Lock()
+	next := peer.next
+	if next != nil {
+		peer.pendingM.Unlock()
+		// the client reconnected and its new peer has the queue
+		return next.sendOrQueue(typ, args)
+	}
+	defer peer.pendingM.Unlock()
+	if peer.connected() {
+		peer.logger.Infof("Sending message: %s", msgJ)
+		return peer.cdc.Send(msgJ)
+	}
+	if len(peer.pending) >= maxPendingMessages {
+		peer.pending = peer.pending[1:]
+	}
+	peer.pending = append(peer.pending, msgJ)
+	return nil
+}
+
 func (peer *Peer) GetCandidatePair(ret *CandidatePairStats) error {
 	ret.FP = peer.FP
 	if peer.PC == nil {
@@ -614,20 +762,25 @@ func ExtractFP(certificate *webrtc.Certificate) (string, error) {
 
 // Shutdown is called when it's time to go.Sweet dreams.
 func Shutdown() {
-	var logger *zap.SugaredLogger
-	var err error
+	var wg sync.WaitGroup
 	for _, peer := range Peers {
-		if logger == nil {
-			logger = peer.logger
-		}
 		peer.Close()
 	}
 	for _, p := range Panes.All() {
-		err = p.C.Process.Kill()
-		if err != nil && logger != nil {
-			logger.Error("Failed closing a process: %w", err)
+		p.Lock()
+		running := p.IsRunning
+		p.Unlock()
+		// an exited pane's pid may already belong to another process
+		if !running {
+			continue
 		}
+		wg.Add(1)
+		go func(p *Pane) {
+			defer wg.Done()
+			p.terminate()
+		}(p)
 	}
+	wg.Wait()
 }
 
 // SetLastPeer sets the most recent peer
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/creack/pty"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
@@ -51,3 +52,93 @@ func TestActivePeer(t *testing.T) {
 	activePeer = GetActivePeer()
 	require.Nil(t, activePeer)
 }
+
+func TestBroadcastConnected(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	online := &Peer{FP: "online", logger: logger, cdc: &fakeChannel{}}
+	offline := &Peer{FP: "offline", logger: logger}
+	peersM.Lock()
+	if Peers == nil {
+		Peers = make(map[string]*Peer)
+	}
+	Peers[online.FP] = online
+	Peers[offline.FP] = offline
+	peersM.Unlock()
+	defer func() {
+		peersM.Lock()
+		delete(Peers, online.FP)
+		delete(Peers, offline.FP)
+		peersM.Unlock()
+	}()
+	// other tests' panes may broadcast too, so only this test's types count
+	types := func(msgs [][]byte) []string {
+		var ret []string
+		for _, msgJ := range msgs {
+			var m CTRLMessage
+			require.NoError(t, json.Unmarshal(msgJ, &m))
+			if m.Type == "pane_title" || m.Type == "pane_killed" {
+				ret = append(ret, m.Type)
+			}
+		}
+		return ret
+	}
+	pending := func() [][]byte {
+		offline.pendingM.Lock()
+		defer offline.pendingM.Unlock()
+		return offline.pending
+	}
+	BroadcastConnected("pane_title", &PaneTitleArgs{PaneID: 1, Title: "vim"})
+	require.Equal(t, []string{"pane_title"}, types(online.cdc.(*fakeChannel).messages()))
+	require.Empty(t, types(pending()))
+	BroadcastAll("pane_killed", &PaneKilledArgs{PaneID: 1})
+	require.Equal(t, []string{"pane_title", "pane_killed"},
+		types(online.cdc.(*fakeChannel).messages()))
+	require.Equal(t, []string{"pane_killed"}, types(pending()))
+}
+
+func TestPendingMessages(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	old := &Peer{FP: "pending", logger: logger}
+	require.NoError(t, old.sendOrQueue("pane_exited", &PaneExitedArgs{PaneID: 1}))
+	// a reconnecting client's new peer takes over the queue
+	peer := &Peer{FP: "pending", logger: logger}
+	old.pendingM.Lock()
+	peer.pending = old.pending
+	old.pending = nil
+	old.next = peer
+	old.pendingM.Unlock()
+	require.NoError(t, old.sendOrQueue("pane_exited", &PaneExitedArgs{PaneID: 2}))
+	cdc := &fakeChannel{}
+	peer.setControlChannel(cdc)
+	require.NoError(t, peer.sendOrQueue("pane_exited", &PaneExitedArgs{PaneID: 3}))
+	var ids []int
+	for _, msgJ := range cdc.messages() {
+		var args PaneExitedArgs
+		m := CTRLMessage{Args: &args}
+		require.NoError(t, json.Unmarshal(msgJ, &m))
+		ids = append(ids, args.PaneID)
+	}
+	require.Equal(t, []int{1, 2, 3}, ids)
+	require.Empty(t, peer.pending)
+}
+
+func TestNotifyResize(t *testing.T) {
+	logger := zaptest.NewLogger(t).Sugar()
+	pane := &Pane{ID: 1001, conf: &Conf{Logger: logger}}
+	peer := &Peer{FP: "resize", logger: logger, cdc: &fakeChannel{}}
+	c := CDB.Add(&fakeChannel{}, pane, peer)
+	defer CDB.Delete(c)
+	require.False(t, pane.Resize(nil))
+	require.True(t, pane.Resize(&pty.Winsize{Rows: 24, Cols: 80}))
+	require.False(t, pane.Resize(&pty.Winsize{Rows: 24, Cols: 80}))
+	pane.NotifyResize()
+	msgs := peer.cdc.(*fakeChannel).messages()
+	require.Len(t, msgs, 1)
+	var m struct {
+		Type string     `json:"type"`
+		Args ResizeArgs `json:"args"`
+	}
+	require.NoError(t, json.Unmarshal(msgs[0], &m))
+	require.Equal(t, "resize", m.Type)
+	require.Equal(t, ResizeArgs{PaneID: 1001, Sx: 80, Sy: 24}, m.Args)
+}
@@ -0,0 +1,165 @@
+// This file holds the code that detects the TCP ports the panes' processes
+// listen on and lets the peers know when they're opened & closed
+package peers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/unix"
+)
+
+// portKey identifies a listening port. A port that is listened on both IPv4
+// & IPv6 is reported once
+type portKey struct {
+	paneID int
+	port   int
+}
+
+var (
+	openPorts  = map[portKey]PortArgs{}
+	openPortsM sync.Mutex
+)
+
+// paneSessions returns the running panes by their session id. The panes'
+// processes are session leaders, so the session id is the process id
+func paneSessions() map[int]*Pane {
+	ret := make(map[int]*Pane)
+	for _, pane := range Panes.All() {
+		pane.Lock()
+		if pane.IsRunning && pane.C != nil && pane.C.Process != nil {
+			ret[pane.C.Process.Pid] = pane
+		}
+		pane.Unlock()
+	}
+	return ret
+}
+
+// scanPorts returns the TCP ports the processes in the panes' sessions listen
+// on
+func scanPorts() map[portKey]PortArgs {
+	ret := make(map[portKey]PortArgs)
+	sessions := paneSessions()
+	if len(sessions) == 0 {
+		return ret
+	}
+	pids, err := process.Pids()
+	if err != nil {
+		return ret
+	}
+	for _, pid := range pids {
+		sid, err := unix.Getsid(int(pid))
+		if err != nil {
+			continue
+		}
+		pane, ok := sessions[sid]
+		if !ok {
+			continue
+		}
+		conns, err := psnet.ConnectionsPid("tcp", pid)
+		if err != nil {
+			continue
+		}
+		name := ""
+		for _, c := range conns {
+			if c.Status != "LISTEN" {
+				continue
+			}
+			key := portKey{pane.ID, int(c.Laddr.Port)}
+			if _, ok := ret[key]; ok {
+				continue
+			}
+			if name == "" {
+				p, err := process.NewProcess(pid)
+				if err == nil {
+					name, _ = p.Name()
+				}
+			}
+			ret[key] = PortArgs{
+				PaneID:  pane.ID,
+				Port:    key.port,
+				Address: c.Laddr.IP,
+				PID:     int(pid),
+				Process: name,
+			}
+		}
+	}
+	return ret
+}
+
+// sortPorts sorts ports by pane & port
+func sortPorts(ports []PortArgs) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].PaneID != ports[j].PaneID {
+			return ports[i].PaneID < ports[j].PaneID
+		}
+		return ports[i].Port < ports[j].Port
+	})
+}
+
+// updatePorts replaces the open ports and returns the ports that were opened
+// & closed since the last update
+func updatePorts(ports map[portKey]PortArgs) ([]PortArgs, []PortArgs) {
+	var opened, closed []PortArgs
+	openPortsM.Lock()
+	for k, p := range ports {
+		if _, ok := openPorts[k]; !ok {
+			opened = append(opened, p)
+		}
+	}
+	for k, p := range openPorts {
+		if _, ok := ports[k]; !ok {
+			closed = append(closed, p)
+		}
+	}
+	openPorts = ports
+	openPortsM.Unlock()
+	sortPorts(opened)
+	sortPorts(closed)
+	return opened, closed
+}
+
+// CheckPorts scans the panes' listening ports and sends port_opened &
+// port_closed messages to the connected peers. Peers that reconnect use
+// list_ports to get the open ports
+func CheckPorts() {
+	opened, closed := updatePorts(scanPorts())
+	for i := range closed {
+		BroadcastConnected("port_closed", &closed[i])
+	}
+	for i := range opened {
+		BroadcastConnected("port_opened", &opened[i])
+	}
+}
+
+// WatchPorts checks the panes' listening ports every interval, until the
+// context is done
+func WatchPorts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			CheckPorts()
+		}
+	}
+}
+
+// ListPorts returns the ports the panes' processes listen on, as of the last
+// check
+func ListPorts() []PortArgs {
+	openPortsM.Lock()
+	ret := make([]PortArgs, 0, len(openPorts))
+	for _, p := range openPorts {
+		ret = append(ret, p)
+	}
+	openPortsM.Unlock()
+	sortPorts(ret)
+	return ret
+}
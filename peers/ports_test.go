@@ -0,0 +1,65 @@
+package peers
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestUpdatePorts(t *testing.T) {
+	defer updatePorts(map[portKey]PortArgs{})
+	updatePorts(map[portKey]PortArgs{})
+	a := PortArgs{PaneID: 1, Port: 3000, Process: "node"}
+	b := PortArgs{PaneID: 1, Port: 8080, Process: "python3"}
+	opened, closed := updatePorts(map[portKey]PortArgs{{1, 3000}: a})
+	require.Equal(t, []PortArgs{a}, opened)
+	require.Empty(t, closed)
+	opened, closed = updatePorts(map[portKey]PortArgs{{1, 3000}: a, {1, 8080}: b})
+	require.Equal(t, []PortArgs{b}, opened)
+	require.Empty(t, closed)
+	require.Equal(t, []PortArgs{a, b}, ListPorts())
+	opened, closed = updatePorts(map[portKey]PortArgs{{1, 8080}: b})
+	require.Empty(t, opened)
+	require.Equal(t, []PortArgs{a}, closed)
+}
+
+func TestScanPorts(t *testing.T) {
+	if _, err := exec.LookPath("perl"); err != nil {
+		t.Skip("perl is needed to listen on a port")
+	}
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	// the listener is a child of the pane's shell
+	require.NoError(t, pane.Run([]string{"sh", "-c", `perl -MIO::Socket::INET -e '
+$s = IO::Socket::INET->new(Listen => 1, LocalAddr => "127.0.0.1:0") or die;
+print "PORT=", $s->sockport, "\n"; sleep 30'; true`}))
+	var port int
+	for i := 0; i < 40 && port == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		out := string(pane.History(0, false))
+		if i := strings.Index(out, "PORT="); i != -1 {
+			port, _ = strconv.Atoi(strings.TrimSpace(out[i+5:]))
+		}
+	}
+	require.NotZero(t, port)
+	ports := scanPorts()
+	p, ok := ports[portKey{pane.ID, port}]
+	require.True(t, ok, "port %d not found in %v", port, ports)
+	require.Equal(t, "perl", p.Process)
+	require.Equal(t, "127.0.0.1", p.Address)
+	pane.Kill()
+	for i := 0; i < 40 && len(scanPorts()) > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	_, ok = scanPorts()[portKey{pane.ID, port}]
+	require.False(t, ok)
+}
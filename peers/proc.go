@@ -0,0 +1,106 @@
+// This file holds helper functions used to inspect & control the processes
+// running in the panes
+package peers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/unix"
+)
+
+// sessionPids returns the ids of the live processes of a given session
+func sessionPids(sid int) []int {
+	var ret []int
+	pids, err := process.Pids()
+	if err != nil {
+		return nil
+	}
+	for _, pid := range pids {
+		s, err := unix.Getsid(int(pid))
+		if err != nil || s != sid {
+			continue
+		}
+		p, err := process.NewProcess(pid)
+		if err == nil {
+			status, err := p.Status()
+			if err == nil && len(status) > 0 && status[0] == process.Zombie {
+				continue
+			}
+		}
+		ret = append(ret, int(pid))
+	}
+	return ret
+}
+
+// terminateSession sends a signal to all the processes in a session, waits
+// for them to exit and SIGKILLs the survivors once the grace period is over.
+// It returns the ids of the processes that exited and of those it killed.
+func terminateSession(sid int, sig syscall.Signal, grace time.Duration) ([]int, []int) {
+	var exited, killed []int
+	pids := sessionPids(sid)
+	for _, pid := range pids {
+		syscall.Kill(pid, sig)
+	}
+	deadline := time.Now().Add(grace)
+	alive := pids
+	for len(alive) > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		alive = sessionPids(sid)
+	}
+	for _, pid := range alive {
+		syscall.Kill(pid, syscall.SIGKILL)
+		killed = append(killed, pid)
+	}
+	for _, pid := range pids {
+		if !contains(alive, pid) {
+			exited = append(exited, pid)
+		}
+	}
+	return exited, killed
+}
+
+// resolveDir returns the absolute path of dir. Relative paths start at base
+// and a leading "~" is replaced with the user's home directory
+func resolveDir(base string, dir string) (string, error) {
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, dir[1:])
+	} else if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("not a directory")
+	}
+	return dir, nil
+}
+
+func containsString(a []string, v string) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(a []int, v int) bool {
+	for _, x := range a {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package peers
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTerminateSession(t *testing.T) {
+	// the background sleep exits on SIGTERM, the shell and the second sleep
+	// ignore it and have to be killed
+	cmd := exec.Command("sh", "-c", "sleep 10 & trap '' TERM; sleep 10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	require.NoError(t, cmd.Start())
+	go cmd.Wait()
+	sid := cmd.Process.Pid
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, sessionPids(sid), 3)
+	exited, killed := terminateSession(sid, syscall.SIGTERM, 200*time.Millisecond)
+	require.Len(t, exited, 1)
+	require.Len(t, killed, 2)
+	require.Contains(t, killed, sid)
+	time.Sleep(100 * time.Millisecond)
+	require.Empty(t, sessionPids(sid))
+}
+
+func TestPaneExitKeepsJobs(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar(), KillSignal: syscall.SIGTERM,
+		KillGrace: 50 * time.Millisecond}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	require.NoError(t, pane.Run([]string{"sh", "-c",
+		"nohup sleep 10 </dev/null >/dev/null 2>&1 & sleep 0.1"}))
+	pane.Lock()
+	sid := pane.C.Process.Pid
+	pane.Unlock()
+	// the read loop kills the pane after the shell exits
+	time.Sleep(500 * time.Millisecond)
+	pids := sessionPids(sid)
+	require.Len(t, pids, 1)
+	syscall.Kill(pids[0], syscall.SIGKILL)
+}
+
+func TestPaneProcessInfo(t *testing.T) {
+	PtyMux = PtyMuxType{}
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, &pty.Winsize{Rows: 24, Cols: 80}, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	dir := t.TempDir()
+	pane.Cwd = dir
+	require.NoError(t, pane.Run([]string{"sleep", "10"}))
+	defer pane.Kill()
+	time.Sleep(100 * time.Millisecond)
+	info, err := pane.ProcessInfo()
+	require.NoError(t, err)
+	require.Equal(t, pane.ID, info.PaneID)
+	require.Equal(t, "sleep", info.Foreground)
+	require.Equal(t, pane.C.Process.Pid, info.ForegroundPID)
+	require.Equal(t, dir, info.Cwd)
+	require.NotZero(t, info.RSS)
+}
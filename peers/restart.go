@@ -0,0 +1,80 @@
+// This file holds the restart policy used to supervise the panes' processes
+package peers
+
+import (
+	"fmt"
+	"time"
+)
+
+// Restart modes
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	defaultRestartDelay = time.Second
+	maxRestartDelay     = time.Minute
+	// healthyRuntime is how long a process should run for its restarts
+	// count to be reset
+	healthyRuntime = maxRestartDelay
+)
+
+// RestartPolicy holds the settings used when a pane's process exits
+type RestartPolicy struct {
+	// Mode is one of never, on-failure or always
+	Mode string
+	// MaxRestarts limits the number of restarts, 0 for no limit
+	MaxRestarts int
+	// Delay is the delay before the first restart, doubled on each restart
+	Delay time.Duration
+}
+
+// NewRestartPolicy returns a policy based on the add_pane arguments
+func NewRestartPolicy(mode string, maxRestarts int, delay int) (RestartPolicy, error) {
+	if mode == "" {
+		mode = RestartNever
+	}
+	if mode != RestartNever && mode != RestartOnFailure && mode != RestartAlways {
+		return RestartPolicy{}, fmt.Errorf("Unknown restart policy: %q", mode)
+	}
+	if maxRestarts < 0 || delay < 0 {
+		return RestartPolicy{}, fmt.Errorf("Restart limits can not be negative")
+	}
+	return RestartPolicy{
+		Mode:        mode,
+		MaxRestarts: maxRestarts,
+		Delay:       time.Duration(delay) * time.Millisecond,
+	}, nil
+}
+
+// shouldRestart returns true if a process that exited with exitCode after
+// restarts restarts should be restarted
+func (p RestartPolicy) shouldRestart(exitCode int, restarts int) bool {
+	if p.MaxRestarts > 0 && restarts >= p.MaxRestarts {
+		return false
+	}
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0
+	}
+	return false
+}
+
+// delay returns how long to wait before the nth restart
+func (p RestartPolicy) delay(n int) time.Duration {
+	d := p.Delay
+	if d == 0 {
+		d = defaultRestartDelay
+	}
+	for i := 1; i < n && d < maxRestartDelay; i++ {
+		d *= 2
+	}
+	if d > maxRestartDelay {
+		d = maxRestartDelay
+	}
+	return d
+}
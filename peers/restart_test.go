@@ -0,0 +1,27 @@
+package peers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartPolicy(t *testing.T) {
+	_, err := NewRestartPolicy("sometimes", 0, 0)
+	require.NotNil(t, err)
+	p, err := NewRestartPolicy("", 0, 0)
+	require.Nil(t, err)
+	require.False(t, p.shouldRestart(1, 0))
+	p, err = NewRestartPolicy(RestartOnFailure, 2, 100)
+	require.Nil(t, err)
+	require.False(t, p.shouldRestart(0, 0))
+	require.True(t, p.shouldRestart(1, 1))
+	require.False(t, p.shouldRestart(1, 2))
+	require.Equal(t, 100*time.Millisecond, p.delay(1))
+	require.Equal(t, 400*time.Millisecond, p.delay(3))
+	p, err = NewRestartPolicy(RestartAlways, 0, 0)
+	require.Nil(t, err)
+	require.True(t, p.shouldRestart(0, 1000))
+	require.Equal(t, maxRestartDelay, p.delay(20))
+}
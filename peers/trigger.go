@@ -0,0 +1,279 @@
+// This file holds the code that matches the panes' output against regular
+// expressions and runs the triggers' actions
+package peers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trigger actions
+const (
+	TriggerNotify = "notify"
+	TriggerMark   = "mark"
+	TriggerSend   = "send"
+)
+
+// maxTriggerLine is the longest line kept for matching, longer lines are
+// matched in parts
+const maxTriggerLine = 2 * OutBufSize
+
+const (
+	// triggerInputQueue is the number of inputs waiting to be written to the
+	// pane, more inputs are dropped
+	triggerInputQueue = 16
+	// triggerEchoTimeout is how long to wait for the echo of the input sent by
+	// a trigger
+	triggerEchoTimeout = time.Second
+)
+
+var (
+	lastMarker int
+	markerM    sync.Mutex
+)
+
+// NewMarker returns a new buffer marker id
+func NewMarker() int {
+	markerM.Lock()
+	defer markerM.Unlock()
+	lastMarker++
+	return lastMarker
+}
+
+// Trigger pairs a regular expression with an action to run when the pane's
+// output matches it. Each line is matched without its escape sequences.
+type Trigger struct {
+	ID      int    `json:"id,omitempty" toml:"-"`
+	Pattern string `json:"pattern" toml:"pattern"`
+	// Action is one of notify, mark or send
+	Action string `json:"action" toml:"action"`
+	// Input is written to the pane by the send action
+	Input string `json:"input,omitempty" toml:"input,omitempty"`
+	re    *regexp.Regexp
+	// pos is the end of the last match in the current line
+	pos int
+}
+
+// triggerState holds the pane's triggers and the line being matched
+type triggerState struct {
+	triggers []*Trigger
+	lastID   int
+	// line holds the raw output since the last newline
+	line []byte
+	// echo holds the lines of input sent by triggers, waiting to be echoed
+	echo []triggerEcho
+	// input is used to write the send triggers' input to the pane
+	input chan []byte
+}
+
+// triggerEcho is a line of input sent to the pane, matches in its echo are
+// ignored so a trigger can't match its own input
+type triggerEcho struct {
+	text  string
+	until time.Time
+}
+
+// Compile validates the trigger and compiles its regular expression
+func (t *Trigger) Compile() error {
+	if t.Action != TriggerNotify && t.Action != TriggerMark && t.Action != TriggerSend {
+		return fmt.Errorf("Unknown trigger action: %q", t.Action)
+	}
+	if t.Action == TriggerSend && t.Input == "" {
+		return fmt.Errorf("Trigger %q has no input to send", t.Pattern)
+	}
+	re, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return fmt.Errorf("Bad trigger pattern %q: %s", t.Pattern, err)
+	}
+	t.re = re
+	return nil
+}
+
+// AddTriggers adds triggers to the pane. No trigger is added if one of them
+// is not valid
+func (pane *Pane) AddTriggers(triggers []Trigger) error {
+	add := make([]*Trigger, 0, len(triggers))
+	for _, t := range triggers {
+		t := t
+		err := t.Compile()
+		if err != nil {
+			return err
+		}
+		add = append(add, &t)
+	}
+	pane.Lock()
+	defer pane.Unlock()
+	for _, t := range add {
+		pane.triggers.lastID++
+		t.ID = pane.triggers.lastID
+		pane.triggers.triggers = append(pane.triggers.triggers, t)
+	}
+	return nil
+}
+
+// ClearTriggers removes all the pane's triggers
+func (pane *Pane) ClearTriggers() {
+	pane.Lock()
+	defer pane.Unlock()
+	pane.triggers.triggers = nil
+	pane.triggers.line = nil
+	pane.triggers.echo = nil
+}
+
+// Triggers returns the pane's triggers
+func (pane *Pane) Triggers() []Trigger {
+	pane.Lock()
+	defer pane.Unlock()
+	ret := make([]Trigger, 0, len(pane.triggers.triggers))
+	for _, t := range pane.triggers.triggers {
+		ret = append(ret, Trigger{ID: t.ID, Pattern: t.Pattern, Action: t.Action, Input: t.Input})
+	}
+	return ret
+}
+
+// triggerMatch is a match waiting for its action to run
+type triggerMatch struct {
+	trigger *Trigger
+	text    string
+}
+
+// matchTriggers matches the output against the pane's triggers. Text is
+// matched line by line so a line split between reads is matched again once
+// more of it arrives, starting after the last match.
+func (pane *Pane) matchTriggers(b []byte) {
+	var matches []triggerMatch
+	pane.Lock()
+	state := &pane.triggers
+	if len(state.triggers) == 0 {
+		state.line = nil
+		state.echo = nil
+		pane.Unlock()
+		return
+	}
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			state.line = append(state.line, b...)
+			b = nil
+		} else {
+			state.line = append(state.line, b[:i]...)
+			b = b[i+1:]
+		}
+		text := string(StripANSI(state.line))
+		echoStart, echoEnd := state.findEcho(text)
+		for _, t := range state.triggers {
+			if t.pos > len(text) {
+				t.pos = 0
+			}
+			start := t.pos
+			for _, loc := range t.re.FindAllStringIndex(text[start:], -1) {
+				t.pos = start + loc[1]
+				if start+loc[0] < echoEnd && start+loc[1] > echoStart {
+					continue
+				}
+				matches = append(matches, triggerMatch{t, text[start+loc[0] : start+loc[1]]})
+			}
+		}
+		if i != -1 || len(state.line) > maxTriggerLine {
+			if echoEnd > echoStart {
+				state.echo = state.echo[1:]
+			}
+			state.line = nil
+			for _, t := range state.triggers {
+				t.pos = 0
+			}
+		}
+	}
+	pane.Unlock()
+	for _, m := range matches {
+		pane.runTrigger(m.trigger, m.text)
+	}
+}
+
+// findEcho returns the range of the next expected echo in text. When the
+// echo isn't found the range is empty.
+func (state *triggerState) findEcho(text string) (int, int) {
+	for len(state.echo) > 0 && time.Now().After(state.echo[0].until) {
+		state.echo = state.echo[1:]
+	}
+	if len(state.echo) == 0 {
+		return 0, 0
+	}
+	// the input is echoed after the prompt that triggered it
+	e := state.echo[0].text
+	i := strings.LastIndex(text, e)
+	if i == -1 {
+		return 0, 0
+	}
+	return i, i + len(e)
+}
+
+// sendTriggerInput queues input to be written to the pane. The input is
+// written by its own goroutine so a full pty doesn't block the output.
+func (pane *Pane) sendTriggerInput(input string) {
+	pane.Lock()
+	state := &pane.triggers
+	until := time.Now().Add(triggerEchoTimeout)
+	for _, l := range strings.Split(input, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if l != "" {
+			state.echo = append(state.echo, triggerEcho{text: l, until: until})
+		}
+	}
+	if state.input == nil {
+		state.input = make(chan []byte, triggerInputQueue)
+		go pane.triggerInputLoop(state.input)
+	}
+	c := state.input
+	pane.Unlock()
+	select {
+	case c <- []byte(input):
+	default:
+		pane.conf.Logger.Warnf("@%d: trigger input queue is full, dropping input", pane.ID)
+	}
+}
+
+// triggerInputLoop writes the triggers' input to the pane until it's killed
+func (pane *Pane) triggerInputLoop(c chan []byte) {
+	for {
+		select {
+		case <-pane.ctx.Done():
+			return
+		case b := <-c:
+			tty := pane.GetTTY()
+			if tty == nil {
+				continue
+			}
+			_, err := tty.Write(b)
+			if err != nil {
+				pane.conf.Logger.Warnf("@%d: failed to send trigger input: %s", pane.ID, err)
+			}
+		}
+	}
+}
+
+// runTrigger runs the trigger's action
+func (pane *Pane) runTrigger(t *Trigger, text string) {
+	logger := pane.conf.Logger
+	logger.Infof("@%d: trigger %d matched %q", pane.ID, t.ID, text)
+	switch t.Action {
+	case TriggerNotify:
+		pane.sendNotify(NotifyArgs{Reason: NotifyTrigger, Message: text})
+	case TriggerMark:
+		// the marker is set after the output that matched
+		marker := NewMarker()
+		pane.Buffer.Mark(marker)
+		BroadcastConnected("pane_mark", &PaneMarkArgs{
+			PaneID:    pane.ID,
+			Marker:    marker,
+			TriggerID: t.ID,
+			Text:      text,
+		})
+	case TriggerSend:
+		pane.sendTriggerInput(t.Input)
+	}
+}
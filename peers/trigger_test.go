@@ -0,0 +1,117 @@
+package peers
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// nopCloser is a fake tty that keeps the input written to the pane
+type nopCloser struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *nopCloser) Read(b []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.buf.Read(b)
+}
+func (c *nopCloser) Write(b []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.buf.Write(b)
+}
+func (c *nopCloser) String() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.buf.String()
+}
+func (c *nopCloser) Close() error { return nil }
+
+func TestTriggers(t *testing.T) {
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	peer := &Peer{FP: "trigger-test", logger: conf.Logger, cdc: &fakeChannel{}}
+	peersM.Lock()
+	if Peers == nil {
+		Peers = make(map[string]*Peer)
+	}
+	Peers[peer.FP] = peer
+	peersM.Unlock()
+	defer func() {
+		peersM.Lock()
+		delete(Peers, peer.FP)
+		peersM.Unlock()
+	}()
+	pane, err := NewPane(conf, nil, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	tty := &nopCloser{}
+	pane.TTY = tty
+	require.Error(t, pane.AddTriggers([]Trigger{{Pattern: "x", Action: "shout"}}))
+	require.Error(t, pane.AddTriggers([]Trigger{{Pattern: "(", Action: TriggerMark}}))
+	require.NoError(t, pane.AddTriggers([]Trigger{
+		{Pattern: "FAIL: \\w+", Action: TriggerNotify},
+		{Pattern: "Continue\\? \\[y/N\\]", Action: TriggerSend, Input: "y\n"},
+		{Pattern: "^ok", Action: TriggerMark},
+	}))
+	require.Len(t, pane.Triggers(), 3)
+	feed := func(s string) {
+		pane.Buffer.Add([]byte(s))
+		pane.handleOutput([]byte(s))
+	}
+	// the match is split between reads and has escape sequences in it
+	feed("--- \x1b[31mFA")
+	feed("IL\x1b[0m: TestX\r\n")
+	feed("Continue? [y")
+	feed("/N] ")
+	feed("\r\nok\r\n")
+	require.Eventually(t, func() bool { return tty.String() == "y\n" },
+		time.Second, 10*time.Millisecond)
+	n := sentNotifications(t, peer)
+	require.Len(t, n, 1)
+	require.Equal(t, NotifyTrigger, n[0].Reason)
+	require.Equal(t, "FAIL: TestX", n[0].Message)
+	var mark PaneMarkArgs
+	for _, msgJ := range peer.cdc.(*fakeChannel).messages() {
+		m := CTRLMessage{Args: &mark}
+		require.NoError(t, json.Unmarshal(msgJ, &m))
+		if m.Type == "pane_mark" {
+			break
+		}
+	}
+	require.Equal(t, "ok", mark.Text)
+	feed("more")
+	require.Equal(t, "more", string(pane.Buffer.GetSinceMarker(mark.Marker)))
+	pane.ClearTriggers()
+	require.Empty(t, pane.Triggers())
+}
+
+func TestTriggerEcho(t *testing.T) {
+	conf := &Conf{Logger: zap.NewNop().Sugar()}
+	pane, err := NewPane(conf, nil, 0)
+	require.NoError(t, err)
+	defer Panes.Delete(pane.ID)
+	tty := &nopCloser{}
+	pane.TTY = tty
+	require.NoError(t, pane.AddTriggers([]Trigger{
+		{Pattern: "again", Action: TriggerSend, Input: "again\n"},
+	}))
+	pane.handleOutput([]byte("say again: "))
+	require.Eventually(t, func() bool { return tty.String() == "again\n" },
+		time.Second, 10*time.Millisecond)
+	// the echo of the input doesn't fire the trigger again
+	pane.handleOutput([]byte("again\r\n"))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, "again\n", tty.String())
+	pane.ClearTriggers()
+	pane.Lock()
+	require.Nil(t, pane.triggers.line)
+	require.Nil(t, pane.triggers.echo)
+	pane.Unlock()
+}
@@ -0,0 +1,30 @@
+// This file holds the code that watches the ports the panes' processes listen
+// on while the agent runs
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/tuzig/webexec/peers"
+	"go.uber.org/fx"
+)
+
+// portWatchInterval is the time between checks of the panes' listening ports
+const portWatchInterval = 2 * time.Second
+
+// StartPortWatch starts watching the panes' listening ports when the agent
+// starts
+func StartPortWatch(lc fx.Lifecycle) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go peers.WatchPorts(ctx, portWatchInterval)
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+}
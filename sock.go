@@ -12,11 +12,14 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/creack/pty"
 	"github.com/dchest/uniuri"
+	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 	"github.com/tuzig/webexec/peers"
 	"go.uber.org/fx"
@@ -44,8 +47,10 @@ type LiveOffer struct {
 
 // StatusMessage is a struct that holds the response to the status request
 type StatusMessage struct {
-	Version string                     `json:"version"`
-	Peers   []peers.CandidatePairStats `json:"peers,omitempty"`
+	Version   string                     `json:"version"`
+	Peers     []peers.CandidatePairStats `json:"peers,omitempty"`
+	Forwards  []ForwardStats             `json:"forwards,omitempty"`
+	Listeners []ListenerStats            `json:"listeners,omitempty"`
 }
 
 const socketFileName = "webexec.sock"
@@ -177,6 +182,8 @@ func StartSocketServer(lc fx.Lifecycle, s *sockServer, params SocketStartParams)
 	m.Handle("/layout", http.HandlerFunc(s.handleLayout))
 	m.Handle("/offer/", http.HandlerFunc(s.handleOffer))
 	m.Handle("/clipboard", http.HandlerFunc(s.handleClipboard))
+	m.Handle("/panes", http.HandlerFunc(s.handlePanes))
+	m.Handle("/panes/", http.HandlerFunc(s.handlePane))
 	server := http.Server{Handler: &m}
 	lc.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -214,6 +221,8 @@ func (s *sockServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 			ret.Peers = append(ret.Peers, cp)
 		}
 	}
+	ret.Forwards = allForwardStats()
+	ret.Listeners = allListenerStats()
 	b, err := json.Marshal(ret)
 	if err != nil {
 		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
@@ -221,6 +230,343 @@ func (s *sockServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(b)
 }
+
+// handlePanes returns the details of all the panes
+func (s *sockServer) handlePanes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, err := json.Marshal(peers.Panes.List())
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// handlePane handles requests for a single pane, in the form `/panes/{id}`
+// or `/panes/{id}/{action}`
+func (s *sockServer) handlePane(w http.ResponseWriter, r *http.Request) {
+	cs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(cs) != 2 && len(cs) != 3 {
+		http.Error(w, "path should be in the form `/panes/{id}[/{action}]`",
+			http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(cs[1])
+	if err != nil {
+		http.Error(w, "Bad pane id", http.StatusBadRequest)
+		return
+	}
+	pane := peers.Panes.Get(id)
+	if pane == nil {
+		http.Error(w, fmt.Sprintf("Pane %d not found", id), http.StatusNotFound)
+		return
+	}
+	if len(cs) == 2 {
+		switch r.Method {
+		case "GET":
+			s.handlePaneInfo(w, r, pane)
+		case "DELETE":
+			Logger.Infof("@%d: killing the pane on a local request", pane.ID)
+			pane.KillAndNotify()
+			peers.Panes.Delete(pane.ID)
+			w.WriteHeader(http.StatusNoContent)
+		case "PATCH":
+			s.handlePanePatch(w, r, pane)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	switch cs[2] {
+	case "expect":
+		s.handleExpect(w, r, pane)
+	case "keys":
+		s.handleKeys(w, r, pane)
+	case "capture":
+		s.handleCapture(w, r, pane)
+	case "attach":
+		s.handleAttach(w, r, pane)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown action: %q", cs[2]), http.StatusNotFound)
+	}
+}
+
+// handlePaneInfo returns the pane's info & its process info
+func (s *sockServer) handlePaneInfo(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	details := PaneDetails{PaneInfo: pane.Info()}
+	if details.Running {
+		process, err := pane.ProcessInfo()
+		if err != nil {
+			Logger.Warnf("Failed to get pane %d process info: %s", pane.ID, err)
+		} else {
+			details.Process = process
+		}
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// handlePanePatch changes the pane's name
+func (s *sockServer) handlePanePatch(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	var req PanePatchRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse the request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Name == nil {
+		http.Error(w, "Nothing to change", http.StatusBadRequest)
+		return
+	}
+	pane.SetName(*req.Name)
+	s.handlePaneInfo(w, r, pane)
+}
+
+// handleExpect runs an expect script against a pane
+func (s *sockServer) handleExpect(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ExpectRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse the request: %s", err), http.StatusBadRequest)
+		return
+	}
+	Logger.Infof("@%d: running an expect script with %d steps", pane.ID, len(req.Steps))
+	result, err := pane.Expect(r.Context(), req.Steps, time.Duration(req.Timeout)*time.Millisecond)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// handleKeys sends keys to a pane
+func (s *sockServer) handleKeys(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req SendKeysRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse the request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Keys) == 0 {
+		http.Error(w, "No keys to send", http.StatusBadRequest)
+		return
+	}
+	err = pane.SendKeys(req.Keys, req.Literal)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send keys: %s", err), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCapture returns a pane's screen or the last bytes of its output.
+// The query can have `history=N` to get the output, `escapes=true` to keep
+// the escape sequences & `format=json` to get the screen's cells
+func (s *sockServer) handleCapture(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	escapes := q.Get("escapes") == "true"
+	if h := q.Get("history"); h != "" {
+		n, err := strconv.Atoi(h)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Bad history size: %q", h), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(pane.History(n, escapes))
+		return
+	}
+	if q.Get("format") == "json" {
+		screen, err := pane.Screen()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		b, err := json.Marshal(screen)
+		if err != nil {
+			http.Error(w, "Failed to marshal response", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+		return
+	}
+	b, err := pane.ScreenText(escapes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b)
+}
+
+// AttachResize is the control message attached clients send when their
+// terminal is resized
+type AttachResize struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+// localClientQueue is the number of messages waiting to be written to a local
+// client. A client that falls further behind is dropped.
+const localClientQueue = 256
+
+// localClient is a client attached over the unix socket. It gets the pane's
+// output the same way data channels do. The output is written by the client's
+// own goroutine so a stuck terminal doesn't block the pane's other clients
+type localClient struct {
+	conn   *websocket.Conn
+	out    chan []byte
+	m      sync.Mutex
+	closed bool
+}
+
+func newLocalClient(conn *websocket.Conn) *localClient {
+	c := &localClient{conn: conn, out: make(chan []byte, localClientQueue)}
+	go c.writeLoop()
+	return c
+}
+
+// writeLoop writes the queued messages to the websocket
+func (c *localClient) writeLoop() {
+	for b := range c.out {
+		err := c.conn.WriteMessage(websocket.BinaryMessage, b)
+		if err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+func (c *localClient) ReadyState() webrtc.DataChannelState {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return webrtc.DataChannelStateClosed
+	}
+	return webrtc.DataChannelStateOpen
+}
+
+func (c *localClient) Send(b []byte) error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return fmt.Errorf("client is closed")
+	}
+	select {
+	case c.out <- b:
+		c.m.Unlock()
+		return nil
+	default:
+	}
+	c.m.Unlock()
+	c.Close()
+	return fmt.Errorf("client is too slow, dropping it")
+}
+
+func (c *localClient) Close() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.out)
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	return c.conn.Close()
+}
+
+// handleAttach upgrades the connection to a websocket and attaches it to the
+// pane. Binary messages are the pane's input & output and text messages carry
+// an AttachResize. The query can have `rows` & `cols` to resize the pane
+// before its screen is sent
+func (s *sockServer) handleAttach(w http.ResponseWriter, r *http.Request, pane *peers.Pane) {
+	pane.Lock()
+	running := pane.IsRunning
+	pane.Unlock()
+	if !running {
+		http.Error(w, fmt.Sprintf("Pane %d is not running", pane.ID), http.StatusConflict)
+		return
+	}
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		Logger.Warnf("Failed to upgrade an attach request: %s", err)
+		return
+	}
+	rows, _ := strconv.Atoi(r.URL.Query().Get("rows"))
+	cols, _ := strconv.Atoi(r.URL.Query().Get("cols"))
+	if rows > 0 && cols > 0 && pane.GetTTY() != nil {
+		if pane.Resize(&pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}) {
+			pane.NotifyResize()
+		}
+	}
+	lc := newLocalClient(conn)
+	c := peers.CDB.Add(lc, pane, nil)
+	Logger.Infof("@%d: a local client attached", pane.ID)
+	defer func() {
+		peers.CDB.Delete(c)
+		lc.Close()
+		Logger.Infof("@%d: a local client detached", pane.ID)
+	}()
+	err = lc.Send(pane.Dump())
+	if err != nil {
+		return
+	}
+	for {
+		mt, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if mt == websocket.TextMessage {
+			var size AttachResize
+			err = json.Unmarshal(b, &size)
+			if err != nil || size.Rows == 0 || size.Cols == 0 {
+				Logger.Warnf("@%d: got a bad resize from a local client: %q", pane.ID, b)
+				continue
+			}
+			if pane.GetTTY() != nil &&
+				pane.Resize(&pty.Winsize{Rows: size.Rows, Cols: size.Cols}) {
+				pane.NotifyResize()
+			}
+			continue
+		}
+		err = pane.SendInput(b)
+		if err != nil {
+			Logger.Warnf("@%d: failed to send a local client's input: %s", pane.ID, err)
+			return
+		}
+	}
+}
+
 func (s *sockServer) handleLayout(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
 		w.Write(peers.Payload)
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 	"github.com/tuzig/webexec/peers"
@@ -192,3 +195,285 @@ func TestOfferPutCandidates(t *testing.T) {
 	// For incoming handle to finish
 	lifecycle.RequireStop()
 }
+
+// startTestSocket starts a socket server and returns a client for it
+func startTestSocket(t *testing.T, lifecycle *fxtest.Lifecycle, conf *peers.Conf) *http.Client {
+	sockServer := NewSockServer(conf)
+	_, err := StartSocketServer(lifecycle, sockServer, SocketStartParams{t.TempDir() + "/webexec.sock"})
+	require.NoError(t, err, "Failed to start a new server")
+	lifecycle.RequireStart()
+	fp := GetSockFP()
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", fp)
+			},
+		},
+	}
+}
+
+// waitForPane waits for the pane's process to exit
+func waitForPane(pane *peers.Pane) {
+	for i := 0; i < 40; i++ {
+		pane.Lock()
+		running := pane.IsRunning
+		pane.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSockPanes(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{
+		Command: []string{"bash", "-c", "echo hello; sleep 0.3"},
+		Rows:    12,
+		Cols:    34,
+	})
+	require.NoError(t, err)
+	httpc := startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	resp, err := httpc.Get("http://unix/panes")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var list []peers.PaneInfo
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	require.NoError(t, err)
+	var info *peers.PaneInfo
+	for i := range list {
+		if list[i].ID == pane.ID {
+			info = &list[i]
+		}
+	}
+	require.NotNil(t, info, "pane %d is missing from %v", pane.ID, list)
+	require.Equal(t, []string{"bash", "-c", "echo hello; sleep 0.3"}, info.Command)
+	require.EqualValues(t, 12, info.Rows)
+	require.EqualValues(t, 34, info.Cols)
+	require.True(t, info.Running)
+	require.Zero(t, info.Clients)
+	require.NotZero(t, info.Created)
+	waitForPane(pane)
+	require.NotZero(t, pane.Info().LastOutput)
+}
+func TestSockExpect(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{
+		Command: []string{"sh", "-c", "read x; echo got-$x; read y; read z"},
+	})
+	require.NoError(t, err)
+	httpc := startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	steps, err := parseExpectScript([]string{"send", `abc\n`, "expect", `got-(\w+)`})
+	require.NoError(t, err)
+	b, err := json.Marshal(ExpectRequest{Steps: steps})
+	require.NoError(t, err)
+	url := fmt.Sprintf("http://unix/panes/%d/expect", pane.ID)
+	resp, err := httpc.Post(url, "application/json", bytes.NewReader(b))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result peers.ExpectResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.True(t, result.OK, result.Error)
+	require.Equal(t, [][]string{{"got-abc", "abc"}}, result.Captures)
+	require.Contains(t, result.Transcript, "got-abc")
+	// a step that times out
+	b, err = json.Marshal(ExpectRequest{Steps: []peers.ExpectStep{
+		{Send: "bye\n", Expect: "never", Timeout: 200}}})
+	require.NoError(t, err)
+	resp, err = httpc.Post(url, "application/json", bytes.NewReader(b))
+	require.NoError(t, err)
+	result = peers.ExpectResult{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.False(t, result.OK)
+	require.Equal(t, 1, result.Step)
+	require.Contains(t, result.Error, "never")
+	// a bad pattern
+	resp, err = httpc.Post(url, "application/json",
+		bytes.NewReader([]byte(`{"steps": [{"expect": "("}]}`)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = httpc.Post("http://unix/panes/9999/expect", "application/json",
+		bytes.NewReader(b))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.NoError(t, pane.SendInput([]byte("z\n")))
+	waitForPane(pane)
+}
+func TestSockSendKeys(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{
+		Command: []string{"sh", "-c", "read x; echo got-$x"},
+	})
+	require.NoError(t, err)
+	out, done := pane.Subscribe()
+	defer done()
+	httpc := startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	url := fmt.Sprintf("http://unix/panes/%d/keys", pane.ID)
+	resp, err := httpc.Post(url, "application/json",
+		bytes.NewReader([]byte(`{"keys": []}`)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = httpc.Post(url, "application/json",
+		bytes.NewReader([]byte(`{"keys": ["abc", "Enter"]}`)))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	var got []byte
+	timeout := time.After(3 * time.Second)
+	for !bytes.Contains(got, []byte("got-abc")) {
+		select {
+		case b, ok := <-out:
+			require.True(t, ok, "pane closed before echoing the input: %q", got)
+			got = append(got, b...)
+		case <-timeout:
+			t.Fatalf("timeout waiting for the input, got: %q", got)
+		}
+	}
+	waitForPane(pane)
+}
+func TestSockCapture(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{
+		Command: []string{"sh", "-c", "echo hello; read x"},
+	})
+	require.NoError(t, err)
+	httpc := startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	get := func(query string) (int, string) {
+		resp, err := httpc.Get(fmt.Sprintf("http://unix/panes/%d/capture?%s", pane.ID, query))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+	var body string
+	for i := 0; i < 40 && !strings.HasPrefix(body, "hello"); i++ {
+		time.Sleep(50 * time.Millisecond)
+		_, body = get("")
+	}
+	require.True(t, strings.HasPrefix(body, "hello\n"), "screen: %q", body)
+	status, body := get("history=7")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "hello\r\n", body)
+	status, body = get("format=json")
+	require.Equal(t, http.StatusOK, status)
+	var screen peers.Screen
+	require.NoError(t, json.Unmarshal([]byte(body), &screen))
+	require.Equal(t, "h", screen.Lines[0][0].Char)
+	require.Equal(t, 1, screen.Cursor.Y)
+	status, _ = get("history=-1")
+	require.Equal(t, http.StatusBadRequest, status)
+	require.NoError(t, pane.SendInput([]byte("\n")))
+	waitForPane(pane)
+}
+func TestSockAttach(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{
+		Command: []string{"sh", "-c", "read x; echo got-$x; read y"},
+	})
+	require.NoError(t, err)
+	startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	dialer := websocket.Dialer{
+		NetDial: func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", GetSockFP())
+		},
+	}
+	conn, _, err := dialer.Dial(
+		fmt.Sprintf("ws://unix/panes/%d/attach?rows=30&cols=100", pane.ID), nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	// the first message restores the screen
+	_, b, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Contains(t, string(b), "\x1b[1;1H")
+	require.EqualValues(t, 30, pane.Ws.Rows)
+	require.Equal(t, 1, pane.Info().Clients)
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("abc\n")))
+	var got []byte
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for !bytes.Contains(got, []byte("got-abc")) {
+		_, b, err := conn.ReadMessage()
+		require.NoError(t, err, "got: %q", got)
+		got = append(got, b...)
+	}
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage,
+		[]byte(`{"rows": 40, "cols": 120}`)))
+	require.NoError(t, conn.WriteMessage(websocket.BinaryMessage, []byte("bye\n")))
+	// the connection is closed when the pane ends
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	require.EqualValues(t, 40, pane.Ws.Rows)
+	require.EqualValues(t, 120, pane.Ws.Cols)
+	waitForPane(pane)
+	require.Equal(t, 0, pane.Info().Clients)
+}
+func TestSockPaneREST(t *testing.T) {
+	initTest(t)
+	lifecycle := fxtest.NewLifecycle(t)
+	conf := &peers.Conf{Logger: Logger}
+	pane, err := launchAutostart(conf, &Autostart{Command: []string{"cat"}})
+	require.NoError(t, err)
+	httpc := startTestSocket(t, lifecycle, conf)
+	defer lifecycle.RequireStop()
+	url := fmt.Sprintf("http://unix/panes/%d", pane.ID)
+	do := func(method string, body string) (int, []byte) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := httpc.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, b
+	}
+	status, b := do("GET", "")
+	require.Equal(t, http.StatusOK, status)
+	var details PaneDetails
+	require.NoError(t, json.Unmarshal(b, &details))
+	require.Equal(t, pane.ID, details.ID)
+	require.Equal(t, []string{"cat"}, details.Command)
+	require.NotNil(t, details.Process)
+	require.Equal(t, "cat", details.Process.Foreground)
+	status, b = do("PATCH", `{"name": "logs"}`)
+	require.Equal(t, http.StatusOK, status)
+	details = PaneDetails{}
+	require.NoError(t, json.Unmarshal(b, &details))
+	require.Equal(t, "logs", details.Name)
+	status, _ = do("PATCH", `{}`)
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = do("PUT", "")
+	require.Equal(t, http.StatusMethodNotAllowed, status)
+	status, _ = do("DELETE", "")
+	require.Equal(t, http.StatusNoContent, status)
+	require.Nil(t, peers.Panes.Get(pane.ID))
+	status, _ = do("GET", "")
+	require.Equal(t, http.StatusNotFound, status)
+	waitForPane(pane)
+}
@@ -0,0 +1,119 @@
+// This file holds the code that forwards unix sockets, like an ssh-agent's,
+// from panes to the peers
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/tuzig/webexec/peers"
+)
+
+var socketNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// socketsDir is where the forwarded sockets' directories are created, when
+// empty the run directory is used
+var socketsDir string
+
+// socketEnv returns the environment variable that is set to a forwarded
+// socket's path
+func socketEnv(sf peers.SocketForward) string {
+	if sf.Env == "" && sf.Name == "ssh-agent" {
+		return "SSH_AUTH_SOCK"
+	}
+	return sf.Env
+}
+
+// checkSockets validates the names & environment variables of socket forwards
+func checkSockets(sockets []peers.SocketForward) error {
+	names := make(map[string]bool)
+	for _, sf := range sockets {
+		if !socketNameRegex.MatchString(sf.Name) {
+			return fmt.Errorf("bad socket name: %q", sf.Name)
+		}
+		if names[sf.Name] {
+			return fmt.Errorf("socket %q is forwarded twice", sf.Name)
+		}
+		names[sf.Name] = true
+		env := socketEnv(sf)
+		if env == "" {
+			return fmt.Errorf("socket %q has no env variable", sf.Name)
+		}
+		if !envNameRegex.MatchString(env) {
+			return fmt.Errorf("socket %q has a bad env variable: %q", sf.Name, env)
+		}
+	}
+	return nil
+}
+
+// mergeSockets returns the profile's socket forwards with the ones in args.
+// Sockets in args replace the profile's sockets with the same name
+func mergeSockets(profile []peers.SocketForward, args []peers.SocketForward) []peers.SocketForward {
+	ret := append([]peers.SocketForward{}, args...)
+	for _, p := range profile {
+		found := false
+		for _, a := range args {
+			if a.Name == p.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// forwardSockets creates a unix socket, in a private directory, for each of
+// the pane's socket forwards and sets their environment variables. It should
+// be called before the pane runs. The sockets are closed when the pane is
+// killed and connections are forwarded to the last peer attached to the pane.
+func forwardSockets(peer *peers.Peer, pane *peers.Pane, sockets []peers.SocketForward) error {
+	if len(sockets) == 0 {
+		return nil
+	}
+	err := checkSockets(sockets)
+	if err != nil {
+		return err
+	}
+	if pane.Env == nil {
+		pane.Env = make(map[string]string)
+	}
+	for _, sf := range sockets {
+		// MkdirTemp creates the directory with 0700 permissions
+		parent := socketsDir
+		if parent == "" {
+			parent = RunPath("")
+		}
+		dir, err := os.MkdirTemp(parent, fmt.Sprintf("pane%d-", pane.ID))
+		if err != nil {
+			closePaneListeners(pane)
+			return fmt.Errorf("failed to create the socket's directory: %s", err)
+		}
+		path := filepath.Join(dir, sf.Name+".sock")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			os.RemoveAll(dir)
+			closePaneListeners(pane)
+			return fmt.Errorf("failed to listen on %s: %s", path, err)
+		}
+		os.Chmod(path, 0600)
+		l := addListener(&Listener{peer: peer, pane: pane, socket: sf.Name, ln: ln})
+		go func() {
+			select {
+			case <-pane.Done():
+				l.close()
+			case <-l.closed:
+			}
+		}()
+		pane.Env[socketEnv(sf)] = path
+		Logger.Infof("listener #%d: forwarding %s of pane %d on %s",
+			l.id, sf.Name, pane.ID, path)
+	}
+	return nil
+}
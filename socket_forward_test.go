@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tuzig/webexec/peers"
+)
+
+func TestCheckSockets(t *testing.T) {
+	require.NoError(t, checkSockets([]peers.SocketForward{
+		{Name: "ssh-agent"}, {Name: "gpg-agent", Env: "GPG_AGENT_SOCK"}}))
+	require.Equal(t, "SSH_AUTH_SOCK", socketEnv(peers.SocketForward{Name: "ssh-agent"}))
+	for _, bad := range [][]peers.SocketForward{
+		{{Name: ""}},
+		{{Name: "../ssh-agent"}},
+		{{Name: "gpg-agent"}},
+		{{Name: "gpg-agent", Env: "GPG AGENT"}},
+		{{Name: "ssh-agent"}, {Name: "ssh-agent", Env: "SOCK"}},
+	} {
+		require.Error(t, checkSockets(bad), "%v", bad)
+	}
+}
+
+func TestMergeSockets(t *testing.T) {
+	profile := []peers.SocketForward{{Name: "ssh-agent"}, {Name: "gpg", Env: "A"}}
+	args := []peers.SocketForward{{Name: "gpg", Env: "B"}}
+	require.Equal(t, []peers.SocketForward{{Name: "gpg", Env: "B"}, {Name: "ssh-agent"}},
+		mergeSockets(profile, args))
+	require.Equal(t, profile, mergeSockets(profile, nil))
+}
@@ -0,0 +1,329 @@
+// This file holds the SOCKS5 proxy that lets clients connect to the host's
+// network. Clients open a data channel labeled "socks" for each connection
+// and the agent answers the SOCKS5 handshake and dials out from the host.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/tuzig/webexec/peers"
+)
+
+// SOCKS5 reply codes, as defined in RFC 1928
+const (
+	socksSucceeded byte = iota
+	socksFailure
+	socksNotAllowed
+	socksNetUnreachable
+	socksHostUnreachable
+	socksRefused
+	socksTTLExpired
+	socksCmdNotSupported
+	socksAddrNotSupported
+)
+
+// socksHandshakeTimeout is how long to wait for the client's SOCKS5 request
+const socksHandshakeTimeout = 10 * time.Second
+
+// parseNetRules parses a list of CIDRs. A single IP address is parsed as a
+// network with just the address
+func parseNetRules(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, e := range entries {
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			ip := net.ParseIP(e)
+			if ip == nil {
+				return nil, fmt.Errorf("bad network %q", e)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ipInNets returns true if one of the networks contains ip
+func ipInNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// socksAllowed returns true if the conf allows proxying connections to ip
+func socksAllowed(ip net.IP) bool {
+	return ipInNets(Conf.socksAllow, ip) && !ipInNets(Conf.socksDeny, ip)
+}
+
+// channelStream reads & writes a data channel as a stream of bytes. It is
+// used for the SOCKS5 handshake and stays the channel's message handler once
+// the channel is piped, so no data is lost or reordered
+type channelStream struct {
+	d        *webrtc.DataChannel
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+	buf      []byte
+	deadline time.Time
+}
+
+func newChannelStream(d *webrtc.DataChannel, timeout time.Duration) *channelStream {
+	s := &channelStream{
+		d:        d,
+		messages: make(chan []byte, 16),
+		closed:   make(chan struct{}),
+		deadline: time.Now().Add(timeout),
+	}
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		select {
+		case s.messages <- msg.Data:
+		case <-s.closed:
+		}
+	})
+	d.OnClose(func() {
+		s.once.Do(func() { close(s.closed) })
+	})
+	return s
+}
+
+// Read reads data received on the channel
+func (s *channelStream) Read(p []byte) (int, error) {
+	if len(s.buf) == 0 {
+		select {
+		case s.buf = <-s.messages:
+		case <-s.closed:
+			return 0, io.EOF
+		case <-time.After(time.Until(s.deadline)):
+			return 0, fmt.Errorf("timeout waiting for data")
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Write sends p on the channel
+func (s *channelStream) Write(p []byte) (int, error) {
+	err := s.d.Send(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// next returns the data that was received and not read, waiting for the next
+// message if there's none. It returns io.EOF once the channel is closed
+func (s *channelStream) next() ([]byte, error) {
+	if len(s.buf) > 0 {
+		b := s.buf
+		s.buf = nil
+		return b, nil
+	}
+	select {
+	case b := <-s.messages:
+		return b, nil
+	case <-s.closed:
+	}
+	// messages received before the channel was closed are still delivered
+	select {
+	case b := <-s.messages:
+		return b, nil
+	default:
+		return nil, io.EOF
+	}
+}
+
+// readSOCKSRequest reads a SOCKS5 greeting & CONNECT request and returns the
+// requested host & port. When the request is not supported, it replies with
+// an error
+func readSOCKSRequest(rw io.ReadWriter) (string, int, error) {
+	hdr := make([]byte, 2)
+	_, err := io.ReadFull(rw, hdr)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read greeting: %s", err)
+	}
+	if hdr[0] != 5 {
+		return "", 0, fmt.Errorf("unsupported SOCKS version: %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	_, err = io.ReadFull(rw, methods)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read methods: %s", err)
+	}
+	// only "no authentication" is supported, as the peer is authenticated
+	if bytes.IndexByte(methods, 0) == -1 {
+		rw.Write([]byte{5, 0xff})
+		return "", 0, fmt.Errorf("no supported authentication method")
+	}
+	_, err = rw.Write([]byte{5, 0})
+	if err != nil {
+		return "", 0, err
+	}
+	req := make([]byte, 4)
+	_, err = io.ReadFull(rw, req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read request: %s", err)
+	}
+	if req[0] != 5 {
+		return "", 0, fmt.Errorf("unsupported SOCKS version: %d", req[0])
+	}
+	if req[1] != 1 {
+		writeSOCKSReply(rw, socksCmdNotSupported, nil)
+		return "", 0, fmt.Errorf("unsupported command: %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case 1, 4:
+		l := net.IPv4len
+		if req[3] == 4 {
+			l = net.IPv6len
+		}
+		ip := make([]byte, l)
+		_, err = io.ReadFull(rw, ip)
+		host = net.IP(ip).String()
+	case 3:
+		l := make([]byte, 1)
+		_, err = io.ReadFull(rw, l)
+		if err == nil {
+			name := make([]byte, l[0])
+			_, err = io.ReadFull(rw, name)
+			host = string(name)
+		}
+	default:
+		writeSOCKSReply(rw, socksAddrNotSupported, nil)
+		return "", 0, fmt.Errorf("unsupported address type: %d", req[3])
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read address: %s", err)
+	}
+	port := make([]byte, 2)
+	_, err = io.ReadFull(rw, port)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read port: %s", err)
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// writeSOCKSReply writes a SOCKS5 reply with the address the agent is bound to
+func writeSOCKSReply(w io.Writer, code byte, addr net.Addr) error {
+	ip := net.IPv4zero.To4()
+	port := 0
+	if a, ok := addr.(*net.TCPAddr); ok {
+		ip = a.IP
+		port = a.Port
+	}
+	b := []byte{5, code, 0}
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append(append(b, 1), ip4...)
+	} else {
+		b = append(append(b, 4), ip.To16()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	_, err := w.Write(b)
+	return err
+}
+
+// socksDial resolves the host, checks its addresses are allowed and connects
+// to the first allowed address that accepts. On failure, it returns the
+// SOCKS5 reply code
+func socksDial(host string, port int) (net.Conn, byte, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), forwardDialTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		cancel()
+		if err != nil {
+			return nil, socksHostUnreachable, fmt.Errorf("failed to resolve %s: %s", host, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	var err error
+	allowed := false
+	for _, ip := range ips {
+		if !socksAllowed(ip) {
+			continue
+		}
+		allowed = true
+		var conn net.Conn
+		// dial the checked address and not the name, so it can't change
+		conn, err = net.DialTimeout("tcp",
+			net.JoinHostPort(ip.String(), strconv.Itoa(port)), forwardDialTimeout)
+		if err == nil {
+			return conn, socksSucceeded, nil
+		}
+	}
+	if !allowed {
+		return nil, socksNotAllowed, fmt.Errorf("%s is not allowed", host)
+	}
+	return nil, socksErrorCode(err), err
+}
+
+// socksErrorCode returns the SOCKS5 reply code for a dial error
+func socksErrorCode(err error) byte {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socksRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socksNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return socksHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return socksHostUnreachable
+	}
+	return socksFailure
+}
+
+// handleSOCKSChannel handles a new "socks" data channel. Once open, it reads
+// the SOCKS5 request, connects to the destination and pipes the connection
+func handleSOCKSChannel(peer *peers.Peer, d *webrtc.DataChannel) {
+	s := newChannelStream(d, socksHandshakeTimeout)
+	d.OnOpen(func() {
+		host, port, err := readSOCKSRequest(s)
+		if err != nil {
+			Logger.Infof("Bad SOCKS request from %s: %s", peer.FP, err)
+			d.Close()
+			return
+		}
+		target := net.JoinHostPort(host, strconv.Itoa(port))
+		conn, code, err := socksDial(host, port)
+		if err != nil {
+			Logger.Infof("SOCKS connection to %s failed: %s", target, err)
+			writeSOCKSReply(s, code, nil)
+			d.Close()
+			return
+		}
+		err = writeSOCKSReply(s, socksSucceeded, conn.LocalAddr())
+		if err != nil {
+			conn.Close()
+			d.Close()
+			return
+		}
+		f := newForward("socks", peer.FP, target, conn)
+		Logger.Infof("forward #%d: proxying a connection to %s", f.id, target)
+		f.pipeStream(d, s)
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStream is a SOCKS5 client's side of a connection
+type fakeStream struct {
+	in  *bytes.Buffer
+	out bytes.Buffer
+}
+
+func (s *fakeStream) Read(p []byte) (int, error)  { return s.in.Read(p) }
+func (s *fakeStream) Write(p []byte) (int, error) { return s.out.Write(p) }
+
+func TestNetRules(t *testing.T) {
+	nets, err := parseNetRules([]string{"10.0.0.0/8", "192.168.1.1", "::1", "fc00::/7"})
+	require.NoError(t, err)
+	cases := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"11.1.2.3", false},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"::1", true},
+		{"fd12::1", true},
+		{"2001:db8::1", false},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.allowed, ipInNets(nets, net.ParseIP(c.ip)), c.ip)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "localhost", "10.0.0"} {
+		_, err := parseNetRules([]string{bad})
+		require.Error(t, err, bad)
+	}
+}
+
+func TestReadSOCKSRequest(t *testing.T) {
+	// a domain name
+	s := &fakeStream{in: bytes.NewBuffer([]byte{5, 2, 2, 0,
+		5, 1, 0, 3, 9, 'l', 'o', 'c', 'a', 'l', 'h', 'o', 's', 't', 0x1f, 0x90})}
+	host, port, err := readSOCKSRequest(s)
+	require.NoError(t, err)
+	require.Equal(t, "localhost", host)
+	require.Equal(t, 8080, port)
+	require.Equal(t, []byte{5, 0}, s.out.Bytes())
+	// IPv4 & IPv6
+	s = &fakeStream{in: bytes.NewBuffer([]byte{5, 1, 0, 5, 1, 0, 1, 10, 0, 0, 1, 0, 22})}
+	host, port, err = readSOCKSRequest(s)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", host)
+	require.Equal(t, 22, port)
+	s = &fakeStream{in: bytes.NewBuffer(append([]byte{5, 1, 0, 5, 1, 0, 4},
+		append(net.ParseIP("::1").To16(), 0, 80)...))}
+	host, port, err = readSOCKSRequest(s)
+	require.NoError(t, err)
+	require.Equal(t, "::1", host)
+	require.Equal(t, 80, port)
+	// authentication is not supported
+	s = &fakeStream{in: bytes.NewBuffer([]byte{5, 1, 2})}
+	_, _, err = readSOCKSRequest(s)
+	require.Error(t, err)
+	require.Equal(t, []byte{5, 0xff}, s.out.Bytes())
+	// neither is BIND
+	s = &fakeStream{in: bytes.NewBuffer([]byte{5, 1, 0, 5, 2, 0, 1, 10, 0, 0, 1, 0, 22})}
+	_, _, err = readSOCKSRequest(s)
+	require.Error(t, err)
+	require.Equal(t, []byte{5, 0, 5, socksCmdNotSupported, 0, 1, 0, 0, 0, 0, 0, 0},
+		s.out.Bytes())
+	// SOCKS4
+	s = &fakeStream{in: bytes.NewBuffer([]byte{4, 1, 0, 22, 10, 0, 0, 1, 0})}
+	_, _, err = readSOCKSRequest(s)
+	require.Error(t, err)
+}
+
+func TestWriteSOCKSReply(t *testing.T) {
+	var b bytes.Buffer
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080}
+	require.NoError(t, writeSOCKSReply(&b, socksSucceeded, addr))
+	require.Equal(t, []byte{5, 0, 0, 1, 127, 0, 0, 1, 0x1f, 0x90}, b.Bytes())
+	b.Reset()
+	addr = &net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}
+	require.NoError(t, writeSOCKSReply(&b, socksSucceeded, addr))
+	require.Equal(t, append(append([]byte{5, 0, 0, 4}, net.ParseIP("::1").To16()...), 0, 80),
+		b.Bytes())
+}
@@ -72,7 +72,9 @@ func newPeer(t *testing.T, fp string, certificate *webrtc.Certificate) *peers.Pe
 		GetICEServers: func() ([]webrtc.ICEServer, error) {
 			return []webrtc.ICEServer{}, nil
 		},
-		OnCTRLMsg: handleCTRLMsg,
+		OnCTRLMsg:      handleCTRLMsg,
+		OnCTRLClose:    handleCTRLClose,
+		OnSOCKSChannel: handleSOCKSChannel,
 	}
 	peer, err := peers.NewPeer(fp, &conf)
 	require.NoError(t, err)
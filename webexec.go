@@ -16,7 +16,6 @@ import (
 	"os/exec"
 	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -56,9 +55,6 @@ var (
 		version *semver.Version
 		expire  time.Time
 	}
-	markerM sync.RWMutex
-	// the id of the last marker used
-	lastMarker = 0
 )
 
 func GetWelcome() string {
@@ -320,7 +316,8 @@ func start(c *cli.Context) error {
 				return SocketStartParams{RunPath("webexec.sock")}
 			},
 		),
-		fx.Invoke(httpserver.StartHTTPServer, StartSocketServer, StartPeerbookClient),
+		fx.Invoke(httpserver.StartHTTPServer, StartSocketServer, StartPeerbookClient,
+			StartAutostart, StartPortWatch),
 	)
 	if debug {
 		app.Run()
@@ -331,6 +328,7 @@ func start(c *cli.Context) error {
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 	Logger.Infof("Shutting down")
+	peers.Shutdown()
 	os.Remove(PIDFilePath())
 	return nil
 }
@@ -604,6 +602,27 @@ func statusCMD(c *cli.Context) error {
 		pair.Write(w)
 	}
 	w.Flush()
+	now := time.Now()
+	if len(stats.Listeners) > 0 {
+		label("Listeners")
+		fmt.Println(":")
+		w = tabwriter.NewWriter(os.Stdout, 0, 3, 1, ' ', 0)
+		header(w, "  ID\tFP\tADDRESS\tAGE\n")
+		for _, l := range stats.Listeners {
+			l.Write(w, now)
+		}
+		w.Flush()
+	}
+	if len(stats.Forwards) > 0 {
+		label("Forwarded connections")
+		fmt.Println(":")
+		w = tabwriter.NewWriter(os.Stdout, 0, 3, 1, ' ', 0)
+		header(w, "  ID\tFP\tTYPE\tTARGET\tSENT\tRECEIVED\tAGE\n")
+		for _, f := range stats.Forwards {
+			f.Write(w, now)
+		}
+		w.Flush()
+	}
 	return nil
 }
 func initCMD(c *cli.Context) error {
@@ -753,6 +772,11 @@ func pasteCMD(c *cli.Context) error {
 	return nil
 }
 
+// handleCTRLClose is called when a peer's control channel is closed
+func handleCTRLClose(peer *peers.Peer) {
+	closeListeners(peer)
+}
+
 // handleCTRLMsg handles incoming control messages
 func handleCTRLMsg(peer *peers.Peer, m *peers.CTRLMessage, raw json.RawMessage) {
 	// do nothing on connection open and nil messages
@@ -762,6 +786,24 @@ func handleCTRLMsg(peer *peers.Peer, m *peers.CTRLMessage, raw json.RawMessage)
 	switch m.Type {
 	case "resize":
 		handleResize(peer, *m, raw)
+	case "get_command_history":
+		handleGetCommandHistory(peer, *m, raw)
+	case "get_command_output":
+		handleGetCommandOutput(peer, *m, raw)
+	case "watch_pane":
+		handleWatchPane(peer, *m, raw)
+	case "forward_port":
+		handleForwardPort(peer, *m, raw)
+	case "listen_port":
+		handleListenPort(peer, *m, raw)
+	case "unlisten_port":
+		handleUnlistenPort(peer, *m, raw)
+	case "notify_pane":
+		handleNotifyPane(peer, *m, raw)
+	case "get_pane_info":
+		handleGetPaneInfo(peer, *m, raw)
+	case "signal_pane":
+		handleSignalPane(peer, *m, raw)
 	case "restore":
 		handleRestore(peer, *m, raw)
 	case "get_payload":
@@ -774,6 +816,12 @@ func handleCTRLMsg(peer *peers.Peer, m *peers.CTRLMessage, raw json.RawMessage)
 		handleReconnectPane(peer, *m, raw)
 	case "add_pane":
 		handleAddPane(peer, *m, raw)
+	case "list_panes":
+		handleListPanes(peer, *m)
+	case "list_ports":
+		handleListPorts(peer, *m)
+	case "list_profiles":
+		handleListProfiles(peer, *m)
 	default:
 		Logger.Errorf("Got a control message with unknown type: %q", m.Type)
 		// send nack
@@ -795,6 +843,10 @@ func main() {
 				Name:        "client",
 				Usage:       "manage clients",
 				Subcommands: ClientCommands,
+			}, {
+				Name:        "panes",
+				Usage:       "manage the agent's panes",
+				Subcommands: PaneCommands,
 			}, {
 				Name:   "version",
 				Usage:  "Print version information",
@@ -861,6 +913,66 @@ func main() {
 				Name:   "paste",
 				Usage:  "Paste data from the active peer's clipboard to stdout. If no active peer, use local clipboard",
 				Action: pasteCMD,
+			}, {
+				Name:      "expect",
+				Usage:     "run send & expect steps against a pane and print the transcript & captures",
+				ArgsUsage: "PANE_ID [send TEXT | expect REGEX]...",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "timeout",
+						Usage: "timeout of each expect step in msec",
+						Value: 10000,
+					},
+				},
+				Action: expectCMD,
+			}, {
+				Name:      "send-keys",
+				Usage:     "send keys to a pane, as if they were typed. Key names like Enter, C-c & Up are translated",
+				ArgsUsage: "PANE_ID KEY...",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "literal",
+						Aliases: []string{"l"},
+						Usage:   "send the keys as text, without translating key names",
+					},
+				},
+				Action: sendKeysCMD,
+			}, {
+				Name:      "capture-pane",
+				Usage:     "print a pane's screen or the last bytes of its output",
+				ArgsUsage: "PANE_ID",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "screen",
+						Usage: "print the current screen, the default",
+					},
+					&cli.IntFlag{
+						Name:  "history",
+						Usage: "print the last N bytes of the pane's output, 0 for the whole buffer",
+					},
+					&cli.BoolFlag{
+						Name:    "escapes",
+						Aliases: []string{"e"},
+						Usage:   "keep the escape sequences, to print styled text or raw output",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the screen's cells, their attributes & the cursor as json",
+					},
+				},
+				Action: capturePaneCMD,
+			}, {
+				Name:      "attach",
+				Usage:     "attach the terminal to a pane",
+				ArgsUsage: "PANE_ID",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "detach-key",
+						Usage: "the key that detaches from the pane",
+						Value: "C-\\",
+					},
+				},
+				Action: attachCMD,
 			},
 		},
 	}